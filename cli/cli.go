@@ -39,3 +39,13 @@ func NewCLI(name, version string) *CLI {
 func (c *CLI) Register(cmd *Command) {
 	cmd.RegisterInto(c.CLI)
 }
+
+// SetArgs sets command line arguments to run, after stripping global
+// flags from their beginning.
+func (c *CLI) SetArgs(args []string) {
+	for len(args) > 0 && (args[0] == "-json" || args[0] == "--json") {
+		JSON = true
+		args = args[1:]
+	}
+	c.Args = args
+}
@@ -23,6 +23,7 @@ func NewCommand(usage, synopsis string, runner CommandFunc) *Command {
 	name := strings.SplitN(usage, " ", 2)[0]
 	cmd := &Command{name, usage, synopsis, runner, flag.NewFlagSet(name, flag.ContinueOnError)}
 	cmd.FlagSet.Usage = func() { fmt.Fprintln(os.Stderr, cmd.Help()) }
+	cmd.BoolVar(&JSON, "json", false, "Print results as JSON")
 	return cmd
 }
 
@@ -51,19 +52,65 @@ func (cmd *Command) Usage() string {
 	return cmd.usage
 }
 
+// parse parses flags interspersed with positional arguments, so that
+// both "cmd -flag VM" and "cmd VM -flag" work. Everything after "--" is
+// positional.
+func (cmd *Command) parse(args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := cmd.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := cmd.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if cmd.terminated(args[:len(args)-len(rest)]) {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// terminated is true if parsed flags were ended by "--", rather than by
+// a positional argument. A "--" following a flag that takes a value is
+// the value.
+func (cmd *Command) terminated(parsed []string) bool {
+	n := len(parsed)
+	if n == 0 || parsed[n-1] != "--" {
+		return false
+	}
+	if n == 1 {
+		return true
+	}
+	name := strings.TrimLeft(parsed[n-2], "-")
+	if !strings.HasPrefix(parsed[n-2], "-") || strings.Contains(name, "=") {
+		return true
+	}
+	f := cmd.Lookup(name)
+	if f == nil {
+		return true
+	}
+	bf, isBool := f.Value.(interface{ IsBoolFlag() bool })
+	return isBool && bf.IsBoolFlag()
+}
+
 func (cmd *Command) Run(args []string) int {
-	if err := cmd.Parse(args); err != nil {
+	args, err := cmd.parse(args)
+	if err != nil {
 		if err != flag.ErrHelp {
 			Error(err)
 		}
 		return 1
 	}
-	if err := cmd.runner(cmd.Args()); err != nil {
+	if err := cmd.runner(args); err != nil {
 		if err == ErrUsage {
 			cmd.FlagSet.Usage()
 			return 1
 		}
 		Error(err)
+		Emit(map[string]string{"error": err.Error()}, nil)
 		return 2
 	}
 	return 0
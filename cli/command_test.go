@@ -0,0 +1,51 @@
+package cli
+
+import "io/ioutil"
+import "reflect"
+import "testing"
+
+func TestParseInterspersed(t *testing.T) {
+	defer func() { JSON = false }()
+	for _, tc := range []struct {
+		args, positional []string
+		output           string
+		force, json      bool
+	}{
+		{[]string{"vm"}, []string{"vm"}, "-", false, false},
+		{[]string{"-f", "vm"}, []string{"vm"}, "-", true, false},
+		{[]string{"vm", "-f"}, []string{"vm"}, "-", true, false},
+		{[]string{"vm", "-json", "a=b"}, []string{"vm", "a=b"}, "-", false, true},
+		{[]string{"vm", "-o", "out", "a=b"}, []string{"vm", "a=b"}, "out", false, false},
+		{[]string{"vm", "-o=out", "-f"}, []string{"vm"}, "out", true, false},
+		{[]string{"vm", "--", "-f"}, []string{"vm", "-f"}, "-", false, false},
+		{[]string{"--", "-vm", "-f"}, []string{"-vm", "-f"}, "-", false, false},
+		{[]string{"-f", "--", "vm", "-json"}, []string{"vm", "-json"}, "-", true, false},
+		{[]string{"-o", "--", "vm", "-f"}, []string{"vm"}, "--", true, false},
+	} {
+		JSON = false
+		cmd := NewCommand("test VM [PROPERTY=VALUE...]", "Test", nil)
+		output := cmd.String("o", "-", "Output")
+		force := cmd.Bool("f", false, "Force")
+		positional, err := cmd.parse(tc.args)
+		if err != nil {
+			t.Errorf("%v: %s", tc.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(positional, tc.positional) {
+			t.Errorf("%v: positional %#v, expected %#v", tc.args, positional, tc.positional)
+		}
+		if *output != tc.output || *force != tc.force || JSON != tc.json {
+			t.Errorf("%v: -o=%#v -f=%v -json=%v, expected -o=%#v -f=%v -json=%v",
+				tc.args, *output, *force, JSON, tc.output, tc.force, tc.json)
+		}
+	}
+}
+
+func TestParseUnknownFlag(t *testing.T) {
+	cmd := NewCommand("test VM", "Test", nil)
+	cmd.SetOutput(ioutil.Discard)
+	cmd.FlagSet.Usage = func() {}
+	if _, err := cmd.parse([]string{"vm", "-x"}); err == nil {
+		t.Error("Unknown flag after argument was accepted")
+	}
+}
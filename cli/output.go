@@ -0,0 +1,34 @@
+package cli
+
+import "encoding/json"
+import "io"
+import "os"
+
+// JSON makes commands print their results as JSON instead of text.
+var JSON = false
+
+// StdoutTaken is set by commands that write data (like an export
+// stream) to standard output. JSON results, and errors, are printed to
+// standard error then, so that they don't corrupt the data.
+var StdoutTaken = false
+
+var stdout, stderr io.Writer = os.Stdout, os.Stderr
+
+// Emit prints result of a command. With JSON output, v is printed as
+// JSON; otherwise, text is called to print it (if text is nil, nothing
+// is printed).
+func Emit(v interface{}, text func()) {
+	if !JSON {
+		if text != nil {
+			text()
+		}
+		return
+	}
+	if buf, err := json.MarshalIndent(v, "", "  "); err != nil {
+		Error(err)
+	} else if StdoutTaken {
+		stderr.Write(append(buf, '\n'))
+	} else {
+		stdout.Write(append(buf, '\n'))
+	}
+}
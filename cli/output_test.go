@@ -0,0 +1,45 @@
+package cli
+
+import "bytes"
+import "errors"
+import "testing"
+
+func captureOutput(t *testing.T) (*bytes.Buffer, *bytes.Buffer) {
+	var out, errOut bytes.Buffer
+	origOut, origErr, origJSON, origTaken := stdout, stderr, JSON, StdoutTaken
+	stdout, stderr = &out, &errOut
+	t.Cleanup(func() { stdout, stderr, JSON, StdoutTaken = origOut, origErr, origJSON, origTaken })
+	return &out, &errOut
+}
+
+func TestEmit(t *testing.T) {
+	out, errOut := captureOutput(t)
+	printed := false
+	Emit(map[string]string{"name": "test"}, func() { printed = true })
+	if !printed || out.Len() > 0 {
+		t.Errorf("Text output: printed=%v, JSON %#v", printed, out.String())
+	}
+
+	JSON = true
+	printed = false
+	Emit(map[string]string{"name": "test"}, func() { printed = true })
+	if printed || out.String() != "{\n  \"name\": \"test\"\n}\n" || errOut.Len() > 0 {
+		t.Errorf("JSON output: printed=%v, stdout %#v, stderr %#v", printed, out.String(), errOut.String())
+	}
+}
+
+// Errors don't corrupt data written to standard output.
+func TestEmitErrorStdoutTaken(t *testing.T) {
+	out, errOut := captureOutput(t)
+	cmd := NewCommand("test", "Test", func([]string) error { return errors.New("failed") })
+	JSON, StdoutTaken = true, true
+	if status := cmd.Run(nil); status != 2 {
+		t.Errorf("Exit status %d, expected 2", status)
+	}
+	if out.Len() > 0 {
+		t.Errorf("Error written to stdout: %#v", out.String())
+	}
+	if errOut.String() != "{\n  \"error\": \"failed\"\n}\n" {
+		t.Errorf("Error JSON: %#v", errOut.String())
+	}
+}
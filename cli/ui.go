@@ -35,8 +35,14 @@ func Printf(format string, a ...interface{}) {
 	Output(fmt.Sprintf(format, a...))
 }
 
+// Info messages go to stderr with JSON output, so that they don't mix
+// with the results, and when standard output is taken by data.
 func Info(s string) {
-	fmt.Println(Colorize.AsInfo(s))
+	if JSON || StdoutTaken {
+		fmt.Fprintln(os.Stderr, Colorize.AsInfo(s))
+	} else {
+		fmt.Println(Colorize.AsInfo(s))
+	}
 }
 
 func Infof(format string, a ...interface{}) {
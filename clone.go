@@ -0,0 +1,51 @@
+package main
+
+import "errors"
+import "path"
+import "strings"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+import "github.com/3ofcoins/bheekeeper/vm"
+
+var cloneOpts struct {
+	pool string
+}
+
+var cmdClone = cli.NewCommand("clone VM@SNAPSHOT NAME [PROPERTY=VALUE...]", "Create a new VM from a snapshot",
+	func(args []string) error {
+		if len(args) < 2 {
+			return cli.ErrUsage
+		}
+
+		src := strings.SplitN(args[0], "@", 2)
+		if len(src) != 2 || src[1] == "" {
+			return errors.New("Source must be a snapshot: VM@SNAPSHOT")
+		}
+
+		overrides, err := parseAssignments(args[2:])
+		if err != nil {
+			return err
+		}
+
+		source, err := vm.FindVM(vm.HostExecutor, src[0])
+		if err != nil {
+			return err
+		}
+
+		pool := cloneOpts.pool
+		if pool == "" {
+			pool = path.Dir(source.Volume)
+		}
+
+		cli.Info("Cloning " + args[0] + " to " + args[1])
+		if clone, err := source.Clone(src[1], args[1], path.Join(pool, args[1]), overrides); err != nil {
+			return err
+		} else {
+			cli.Emit(map[string]string{"name": clone.Name, "volume": clone.Volume, "source": args[0]}, nil)
+			return nil
+		}
+	})
+
+func init() {
+	cmdClone.StringVar(&cloneOpts.pool, "pool", "", "Parent dataset (default: same as source VM)")
+}
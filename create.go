@@ -0,0 +1,59 @@
+package main
+
+import "errors"
+import "path"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+import "github.com/3ofcoins/bheekeeper/vm"
+
+var createOpts struct {
+	size, pool, cpus, mem string
+	sparse, image         bool
+}
+
+var cmdCreate = cli.NewCommand("create NAME", "Create ZFS volume (or filesystem with disk image) for a new VM",
+	func(args []string) error {
+		if len(args) != 1 {
+			return cli.ErrUsage
+		}
+		if createOpts.size == "" {
+			return errors.New("Volume size (-size) is required")
+		}
+
+		pool := createOpts.pool
+		if pool == "" {
+			if defaultPool, err := vm.DefaultPool(vm.HostExecutor); err != nil {
+				return err
+			} else {
+				pool = defaultPool
+			}
+		}
+
+		vm := vm.NewVM(vm.HostExecutor, args[0], path.Join(pool, args[0]))
+		if createOpts.cpus != "" {
+			vm.Properties["cpus"] = createOpts.cpus
+		}
+		if createOpts.mem != "" {
+			vm.Properties["mem"] = createOpts.mem
+		}
+
+		cli.Info("Creating: " + vm.Name)
+		if createOpts.image {
+			if err := vm.CreateImage(createOpts.size); err != nil {
+				return err
+			}
+		} else if err := vm.Create(createOpts.size, createOpts.sparse); err != nil {
+			return err
+		}
+		cli.Emit(map[string]string{"name": vm.Name, "volume": vm.Volume}, nil)
+		return nil
+	})
+
+func init() {
+	cmdCreate.StringVar(&createOpts.size, "size", "", "Volume size (e.g. 20G)")
+	cmdCreate.StringVar(&createOpts.pool, "pool", "", "Parent dataset (default: first ZFS pool)")
+	cmdCreate.StringVar(&createOpts.cpus, "cpus", "", "Number of virtual CPUs")
+	cmdCreate.StringVar(&createOpts.mem, "mem", "", "Memory size")
+	cmdCreate.BoolVar(&createOpts.sparse, "sparse", false, "Create a sparse volume")
+	cmdCreate.BoolVar(&createOpts.image, "image", false, "Create a ZFS filesystem with boot disk image file instead of a volume")
+}
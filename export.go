@@ -0,0 +1,71 @@
+package main
+
+import "io"
+import "os"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+import "github.com/3ofcoins/bheekeeper/vm"
+
+var exportOpts struct {
+	snapshot, incremental, output string
+}
+
+var cmdExport = newVMCommand("export", "Export VM as a single stream", func(vm *vm.VM) error {
+	var w io.Writer = os.Stdout
+	if exportOpts.output == "-" {
+		cli.StdoutTaken = true
+	} else {
+		f, err := os.Create(exportOpts.output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+		cli.Info("Exporting " + vm.Name + " to " + exportOpts.output)
+	}
+	if err := vm.Export(w, exportOpts.snapshot, exportOpts.incremental); err != nil {
+		return err
+	}
+	cli.Emit(map[string]string{"name": vm.Name, "output": exportOpts.output}, nil)
+	return nil
+})
+
+func init() {
+	cmdExport.StringVar(&exportOpts.snapshot, "snapshot", "", "Snapshot to export (default: take a new one)")
+	cmdExport.StringVar(&exportOpts.incremental, "incremental", "", "Export only changes since this earlier snapshot")
+	cmdExport.StringVar(&exportOpts.output, "o", "-", "Output file, or - for standard output")
+}
+
+var importOpts struct {
+	name, pool string
+}
+
+var cmdImport = cli.NewCommand("import FILE", "Import VM exported with export (FILE may be - for standard input)",
+	func(args []string) error {
+		if len(args) != 1 {
+			return cli.ErrUsage
+		}
+
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		if vm, err := vm.Import(vm.HostExecutor, r, importOpts.name, importOpts.pool); err != nil {
+			return err
+		} else {
+			cli.Info("Imported: " + vm.Name)
+			cli.Emit(map[string]string{"name": vm.Name, "volume": vm.Volume}, nil)
+			return nil
+		}
+	})
+
+func init() {
+	cmdImport.StringVar(&importOpts.name, "name", "", "Name of the imported VM (default: name from the stream)")
+	cmdImport.StringVar(&importOpts.pool, "pool", "", "Parent dataset (default: first ZFS pool)")
+}
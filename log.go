@@ -0,0 +1,24 @@
+package main
+
+import "os"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+import "github.com/3ofcoins/bheekeeper/vm"
+
+var logOpts struct {
+	follow bool
+	lines  int
+}
+
+var cmdLog = newVMArgsCommand("log", "[-f] [-n N]", "Show console log of VM",
+	func(vm *vm.VM, args []string) error {
+		if len(args) != 0 {
+			return cli.ErrUsage
+		}
+		return vm.ConsoleLog(os.Stdout, logOpts.lines, logOpts.follow)
+	})
+
+func init() {
+	cmdLog.BoolVar(&logOpts.follow, "f", false, "Keep showing new console output")
+	cmdLog.IntVar(&logOpts.lines, "n", 0, "Show only last N lines")
+}
@@ -2,16 +2,47 @@ package main
 
 import "fmt"
 import "os"
+import "strings"
+import "time"
 
 import "github.com/3ofcoins/bheekeeper/cli"
 import "github.com/3ofcoins/bheekeeper/vm"
 
+var statusOpts struct {
+	schema bool
+}
+
 var cmdStatus = cli.NewCommand("status [VM]", "List VMs or show detailed info about one",
 	func(args []string) error {
+		if statusOpts.schema {
+			switch len(args) {
+			case 0:
+				emitSchema(nil)
+			case 1:
+				if vm, err := vm.FindVM(vm.HostExecutor, args[0]); err != nil {
+					return err
+				} else {
+					emitSchema(vm)
+				}
+			default:
+				return cli.ErrUsage
+			}
+			return nil
+		}
+
 		switch len(args) {
 		case 0:
-			if vms, err := vm.AllVMs(); err != nil {
+			if vms, err := vm.AllVMs(vm.HostExecutor); err != nil {
 				return err
+			} else if cli.JSON {
+				infos := make([]*vm.Info, len(vms))
+				for i, vm := range vms {
+					if err := vm.LoadProperties(); err != nil {
+						return err
+					}
+					infos[i] = vm.Info()
+				}
+				cli.Emit(infos, nil)
 			} else {
 				if len(vms) == 0 {
 					cli.Info("No VMs configured")
@@ -27,23 +58,11 @@ var cmdStatus = cli.NewCommand("status [VM]", "List VMs or show detailed info ab
 				}
 			}
 		case 1:
-			if vm, err := vm.FindVM(args[0]); err != nil {
+			if vm, err := vm.FindVM(vm.HostExecutor, args[0]); err != nil {
 				return err
 			} else {
-				cli.Printf("Name: %v\nMAC: %s\nExists: %v\nZFS Volume: %v",
-					vm.Name, vm.MAC(), vm.Exists(), vm.Volume)
-				if vm.Exists() {
-					if pid := vm.BhyvePid(); pid != 0 {
-						cli.Printf("Bhyve PID: %d", pid)
-					}
-					if tap := vm.Tap(false); tap != "" {
-						cli.Printf("Interface: %s", tap)
-					}
-				}
-				cli.Output("Properties:")
-				for prop, val := range vm.Properties {
-					cli.Printf("  %v: %v", prop, val)
-				}
+				info := vm.Info()
+				cli.Emit(info, func() { printInfo(info) })
 			}
 		default:
 			return cli.ErrUsage
@@ -51,12 +70,91 @@ var cmdStatus = cli.NewCommand("status [VM]", "List VMs or show detailed info ab
 		return nil
 	})
 
+func printInfo(info *vm.Info) {
+	cli.Printf("Name: %v\nMAC: %s\nExists: %v\nZFS Volume: %v",
+		info.Name, info.MAC, info.Exists, info.Volume)
+	if info.Pid != 0 {
+		cli.Printf("Bhyve PID: %d", info.Pid)
+	}
+	cpu := fmt.Sprintf("CPUs: %d", info.CPU.CPUs)
+	if info.CPU.Sockets != 0 {
+		cpu += fmt.Sprintf(" (%d sockets, %d cores, %d threads)", info.CPU.Sockets, info.CPU.Cores, info.CPU.Threads)
+	}
+	if len(info.CPU.Pinning) > 0 {
+		cpu += ", pinned " + strings.Join(info.CPU.Pinning, " ")
+	}
+	cli.Output(cpu)
+	if info.WiredMemory {
+		cli.Output("Memory: wired")
+	}
+	for _, disk := range info.Disks {
+		cli.Printf("Disk %d: %s %s", disk.Index, disk.Emulation, strings.Join(append([]string{disk.Path}, disk.Options...), ","))
+	}
+	for i, nic := range info.NICs {
+		var tap string
+		if i < len(info.Taps) {
+			tap = info.Taps[i] + " "
+		}
+		cli.Printf("Interface %d: %s%s on %s, MAC %s", nic.Index, tap, nic.Model, nic.Bridge, nic.MAC)
+	}
+	if info.ConsoleLog != "" {
+		cli.Printf("Console log: %s", info.ConsoleLog)
+	}
+	for _, sp := range info.SerialPorts {
+		cli.Printf("Serial %s: %s %s", sp.Port, sp.Backend, sp.Path)
+	}
+	for _, share := range info.Shares {
+		var ro string
+		if share.ReadOnly {
+			ro = " (read-only)"
+		}
+		cli.Printf("Share %s: %s%s", share.Tag, share.Path, ro)
+	}
+	for _, ch := range info.Channels {
+		cli.Printf("Channel %s: %s", ch.Name, ch.Path)
+	}
+	if len(info.PCI) > 0 {
+		var slots []string
+		for _, dev := range info.PCI {
+			slots = append(slots, dev.Address.String()+" "+dev.Name)
+		}
+		cli.Printf("PCI: %s", strings.Join(slots, ", "))
+	}
+	if info.SupervisorPid != 0 {
+		cli.Printf("Supervisor PID: %d", info.SupervisorPid)
+	}
+	if info.VNC != "" {
+		cli.Printf("VNC: %s", info.VNC)
+	} else if hasVNC, _ := vm.ParseBool(info.Properties["vnc"].Value); hasVNC {
+		cli.Printf("VNC: %s (port allocated on start)", info.Properties["vnc:listen"].Value)
+	}
+	if st := info.State; st != nil {
+		cli.Printf("Console: %s\nStarted: %s\nBoots: %d",
+			st.Console, st.StartedAt.Format(time.RFC3339), st.Boots)
+	}
+	cli.Output("Properties:")
+	for _, name := range sortedNames(info.Properties) {
+		prop := info.Properties[name]
+		cli.Printf("  %v: %v (%s)", name, prop.Value, prop.Source)
+	}
+}
+
+// emitResult reports successful completion of an action on a VM; it
+// is printed only with JSON output.
+func emitResult(vm *vm.VM, result string) {
+	cli.Emit(map[string]string{"name": vm.Name, "result": result}, nil)
+}
+
+func init() {
+	cmdStatus.BoolVar(&statusOpts.schema, "schema", false, "Document properties (and show VM's values)")
+}
+
 func newVMCommand(name, synopsis string, runner func(*vm.VM) error) *cli.Command {
 	return cli.NewCommand(name+" VM", synopsis, func(args []string) error {
 		if len(args) != 1 {
 			return cli.ErrUsage
 		}
-		if vm, err := vm.FindVM(args[0]); err != nil {
+		if vm, err := vm.FindVM(vm.HostExecutor, args[0]); err != nil {
 			return err
 		} else {
 			return runner(vm)
@@ -64,24 +162,128 @@ func newVMCommand(name, synopsis string, runner func(*vm.VM) error) *cli.Command
 	})
 }
 
+// newVMArgsCommand is like newVMCommand, but passes the arguments
+// following VM's name to the runner.
+func newVMArgsCommand(name, usage, synopsis string, runner func(*vm.VM, []string) error) *cli.Command {
+	return cli.NewCommand(name+" VM "+usage, synopsis, func(args []string) error {
+		if len(args) < 1 {
+			return cli.ErrUsage
+		}
+		if vm, err := vm.FindVM(vm.HostExecutor, args[0]); err != nil {
+			return err
+		} else {
+			return runner(vm, args[1:])
+		}
+	})
+}
+
+func humanSize(bytes int64) string {
+	const units = "KMGTPE"
+	if bytes < 1024 {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	size, unit := float64(bytes)/1024, 0
+	for ; size >= 1024 && unit < len(units)-1; unit++ {
+		size /= 1024
+	}
+	return fmt.Sprintf("%.1f%c", size, units[unit])
+}
+
+var runOpts struct {
+	detach, supervise bool
+}
+
 var cmdRun = newVMCommand("run", "Run VM", func(vm *vm.VM) error {
-	return vm.Run()
+	switch {
+	case runOpts.supervise:
+		return vm.Supervise()
+	case runOpts.detach:
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if pid, err := vm.Detach(self, "run", "-supervise", vm.Name); err != nil {
+			return err
+		} else {
+			cli.Infof("Started %s in background (pid %d), console: %s", vm.Name, pid, vm.ConsoleSocket())
+			cli.Emit(map[string]interface{}{
+				"name":           vm.Name,
+				"supervisor_pid": pid,
+				"console":        vm.ConsoleSocket(),
+			}, nil)
+			return nil
+		}
+	default:
+		if err := vm.RunAttached(); err != nil {
+			return err
+		}
+		emitResult(vm, "stopped")
+		return nil
+	}
 })
 
+func init() {
+	cmdRun.BoolVar(&runOpts.detach, "d", false, "Run in background, with console on a unix socket (see console command)")
+	cmdRun.BoolVar(&runOpts.supervise, "supervise", false, "Supervise VM in foreground (used internally by -d)")
+}
+
+var cmdConsole = newVMCommand("console", "Attach to console of a running VM",
+	func(vm *vm.VM) error {
+		return vm.AttachConsole()
+	})
+
 var cmdDestroy = newVMCommand("destroy", "Destroy VM", func(vm *vm.VM) error {
 	if vm.Exists() {
 		cli.Info("Destroying: " + vm.Name)
-		return vm.RunBhyvectl("--destroy")
+		// Forced stop, so that it's not restarted
+		if err := vm.Stop(0, true); err != nil {
+			return err
+		}
+		emitResult(vm, "destroyed")
+		return nil
 	} else {
 		return fmt.Errorf("VM does not exist: %s", vm.Name)
 	}
 })
 
+var stopOpts struct {
+	timeout time.Duration
+	force   bool
+}
+
+var cmdStop = newVMCommand("stop", "Shut VM down gracefully", func(vm *vm.VM) error {
+	cli.Info("Stopping: " + vm.Name)
+	if err := vm.Stop(stopOpts.timeout, stopOpts.force); err != nil {
+		return err
+	}
+	emitResult(vm, "stopped")
+	return nil
+})
+
+func init() {
+	cmdStop.DurationVar(&stopOpts.timeout, "timeout", 60*time.Second, "How long to wait for ACPI shutdown")
+	cmdStop.BoolVar(&stopOpts.force, "force", false, "Power off right away, without ACPI shutdown")
+}
+
 func main() {
 	c := cli.NewCLI("bheekeeper", "0.0.1")
-	c.Args = os.Args[1:]
+	c.SetArgs(os.Args[1:])
 	c.Register(cmdStatus)
+	c.Register(cmdCreate)
+	c.Register(cmdGet)
+	c.Register(cmdSet)
+	c.Register(cmdUnset)
 	c.Register(cmdRun)
+	c.Register(cmdStop)
+	c.Register(cmdConsole)
+	c.Register(cmdLog)
+	c.Register(cmdSnapshot)
+	c.Register(cmdSnapshots)
+	c.Register(cmdRollback)
+	c.Register(cmdSnapshotRm)
+	c.Register(cmdClone)
+	c.Register(cmdExport)
+	c.Register(cmdImport)
 	c.Register(cmdDestroy)
 
 	exitStatus, err := c.Run()
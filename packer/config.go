@@ -3,8 +3,6 @@ package packer
 import "errors"
 import "fmt"
 import "net"
-import "os/exec"
-import "path/filepath"
 import "strings"
 
 import "github.com/mitchellh/packer/common"
@@ -13,15 +11,6 @@ import vboxcommon "github.com/mitchellh/packer/builder/virtualbox/common"
 
 import "github.com/3ofcoins/bheekeeper/vm"
 
-func zpool() string {
-	cmd := exec.Command("zpool", "list", "-H")
-	if out, err := cmd.Output(); err != nil {
-		panic(err)
-	} else {
-		return strings.SplitN(string(out), "\t", 2)[0]
-	}
-}
-
 type Config struct {
 	common.PackerConfig `mapstructure:",squash"`
 
@@ -76,8 +65,12 @@ func NewConfig(raws ...interface{}) (*Config, []string, error) {
 	}
 
 	if c.VolumeName == "" {
-		c.VolumeName = fmt.Sprintf("%s/%s", zpool(), c.VMName)
-		warns = append(warns, fmt.Sprintf("volume_name not provided, using %s", c.VolumeName))
+		if pool, err := vm.DefaultPool(vm.HostExecutor); err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+		} else {
+			c.VolumeName = fmt.Sprintf("%s/%s", pool, c.VMName)
+			warns = append(warns, fmt.Sprintf("volume_name not provided, using %s", c.VolumeName))
+		}
 	}
 
 	if c.BootDevice == "" {
@@ -168,7 +161,7 @@ func NewConfig(raws ...interface{}) (*Config, []string, error) {
 	}
 
 	// VM stuff
-	c.vm = vm.NewVM(c.VMName, filepath.Join("/dev/zvol", c.VolumeName))
+	c.vm = vm.NewVM(vm.HostExecutor, c.VMName, c.VolumeName)
 
 	// Bridge address
 	if iface, err := net.InterfaceByName(c.vm.Bridge()); err != nil {
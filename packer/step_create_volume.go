@@ -1,7 +1,6 @@
 package packer
 
 import "fmt"
-import "os/exec"
 import "strconv"
 
 import "github.com/mitchellh/multistep"
@@ -14,17 +13,15 @@ func (s *stepCreateVolume) Run(state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packer.Ui)
 
 	ui.Say("Creating ZFS volume...")
-	cmd := exec.Command("zfs", "create",
-		"-V", strconv.FormatUint(uint64(config.VolumeSize), 10),
-		config.VolumeName)
-	// TODO: keep stderr
-
-	if err := cmd.Run(); err != nil {
+	// VolumeSize is in megabytes, zfs takes bytes without a suffix
+	size := strconv.FormatUint(uint64(config.VolumeSize), 10) + "M"
+	if err := config.vm.Create(size, false); err != nil {
 		err := fmt.Errorf("Error creating ZFS volume: %s", err)
 		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
+	state.Put("vm", config.vm)
 	return multistep.ActionContinue
 }
 
@@ -0,0 +1,124 @@
+package main
+
+import "fmt"
+import "sort"
+import "strings"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+import "github.com/3ofcoins/bheekeeper/vm"
+
+// emitProperties prints named properties of the VM as a table, or as
+// JSON object.
+func emitProperties(v *vm.VM, names []string) {
+	props := make(map[string]vm.PropertyValue)
+	for _, name := range names {
+		props[name] = v.EffectiveProperty(name)
+	}
+	cli.Emit(props, func() {
+		cli.Printf("%-12s %-24s %s", "PROPERTY", "VALUE", "SOURCE")
+		for _, name := range names {
+			value, source := props[name].Value, props[name].Source
+			if source == "" {
+				value, source = "-", "-"
+			}
+			cli.Printf("%-12s %-24s %s", name, value, source)
+		}
+	})
+}
+
+// parseAssignments parses PROPERTY=VALUE arguments.
+func parseAssignments(args []string) (map[string]string, error) {
+	props := make(map[string]string)
+	for _, arg := range args {
+		if kv := strings.SplitN(arg, "=", 2); len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Not a PROPERTY=VALUE pair: %s", arg)
+		} else {
+			props[kv[0]] = kv[1]
+		}
+	}
+	return props, nil
+}
+
+var cmdGet = newVMArgsCommand("get", "[PROPERTY...]", "Show VM properties",
+	func(vm *vm.VM, args []string) error {
+		if len(args) == 0 {
+			args = vm.PropertyNames()
+		}
+		for _, name := range args {
+			if !vm.HasProperty(name) {
+				return fmt.Errorf("Unknown property: %s", name)
+			}
+		}
+		emitProperties(vm, args)
+		return nil
+	})
+
+var cmdSet = newVMArgsCommand("set", "PROPERTY=VALUE...", "Set VM properties",
+	func(vm *vm.VM, args []string) error {
+		if len(args) == 0 {
+			return cli.ErrUsage
+		}
+		props, err := parseAssignments(args)
+		if err != nil {
+			return err
+		}
+		if err := vm.SetProperties(props); err != nil {
+			return err
+		}
+		names := make([]string, len(args))
+		for i, arg := range args {
+			names[i] = strings.SplitN(arg, "=", 2)[0]
+		}
+		emitProperties(vm, names)
+		return nil
+	})
+
+var cmdUnset = newVMArgsCommand("unset", "PROPERTY...", "Reset VM properties to inherited or default value",
+	func(vm *vm.VM, args []string) error {
+		if len(args) == 0 {
+			return cli.ErrUsage
+		}
+		for _, name := range args {
+			if err := vm.UnsetProperty(name); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		}
+		emitProperties(vm, args)
+		return nil
+	})
+
+func sortedNames(props map[string]vm.PropertyValue) []string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// emitSchema documents all known properties; if v is not nil, it also
+// shows their values.
+func emitSchema(v *vm.VM) {
+	var props map[string]vm.PropertyValue
+	if v != nil {
+		props = v.EffectiveProperties()
+	}
+	cli.Emit(map[string]interface{}{"schema": vm.PropertySchema, "properties": props}, func() {
+		for _, spec := range vm.PropertySchema {
+			details := []string{spec.Type.String()}
+			if rng := spec.Range(); rng != "" {
+				details = append(details, rng)
+			}
+			if dfl, hasDefault := vm.PropertyDefaults[spec.Name]; hasDefault {
+				details = append(details, "default: "+dfl)
+			}
+			cli.Printf("%s (%s)", spec.Pattern(), strings.Join(details, ", "))
+			cli.Printf("    %s", spec.Help)
+			for _, name := range sortedNames(props) {
+				if vm.LookupProperty(name) == spec {
+					cli.Printf("    Value of %s: %s (%s)", name, props[name].Value, props[name].Source)
+				}
+			}
+		}
+	})
+}
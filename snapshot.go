@@ -0,0 +1,78 @@
+package main
+
+import "github.com/3ofcoins/bheekeeper/cli"
+import "github.com/3ofcoins/bheekeeper/vm"
+
+var cmdSnapshot = newVMArgsCommand("snapshot", "[NAME]", "Take a snapshot of VM",
+	func(vm *vm.VM, args []string) error {
+		var name string
+		switch len(args) {
+		case 0:
+		case 1:
+			name = args[0]
+		default:
+			return cli.ErrUsage
+		}
+		if name, err := vm.Snapshot(name); err != nil {
+			return err
+		} else {
+			cli.Info("Created snapshot: " + vm.Name + "@" + name)
+			cli.Emit(map[string]string{"name": vm.Name, "snapshot": name}, nil)
+			return nil
+		}
+	})
+
+var cmdSnapshots = newVMCommand("snapshots", "List snapshots of VM",
+	func(vm *vm.VM) error {
+		if snaps, err := vm.Snapshots(); err != nil {
+			return err
+		} else {
+			cli.Emit(snaps, func() {
+				if len(snaps) == 0 {
+					cli.Info("No snapshots")
+					return
+				}
+				cli.Printf("%-24s %-20s %10s", "NAME", "CREATED", "USED")
+				for _, snap := range snaps {
+					cli.Printf("%-24s %-20s %10s",
+						snap.Name, snap.Created.Format("2006-01-02 15:04:05"), humanSize(snap.Used))
+				}
+			})
+		}
+		return nil
+	})
+
+var rollbackOpts struct {
+	force, recursive bool
+}
+
+var cmdRollback = newVMArgsCommand("rollback", "SNAPSHOT", "Roll VM back to a snapshot",
+	func(vm *vm.VM, args []string) error {
+		if len(args) != 1 {
+			return cli.ErrUsage
+		}
+		cli.Info("Rolling back: " + vm.Name + "@" + args[0])
+		if err := vm.Rollback(args[0], rollbackOpts.force, rollbackOpts.recursive); err != nil {
+			return err
+		}
+		emitResult(vm, "rolled back")
+		return nil
+	})
+
+func init() {
+	cmdRollback.BoolVar(&rollbackOpts.force, "force", false, "Roll back even if VM is running")
+	cmdRollback.BoolVar(&rollbackOpts.recursive, "r", false, "Destroy snapshots newer than SNAPSHOT")
+}
+
+var cmdSnapshotRm = newVMArgsCommand("snapshot-rm", "SNAPSHOT", "Destroy a snapshot of VM",
+	func(vm *vm.VM, args []string) error {
+		if len(args) != 1 {
+			return cli.ErrUsage
+		}
+		cli.Info("Destroying snapshot: " + vm.Name + "@" + args[0])
+		if err := vm.DestroySnapshot(args[0]); err != nil {
+			return err
+		}
+		emitResult(vm, "snapshot destroyed")
+		return nil
+	})
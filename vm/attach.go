@@ -0,0 +1,205 @@
+package vm
+
+import "errors"
+import "fmt"
+import "io"
+import "net"
+import "os"
+import "strconv"
+import "syscall"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+const consoleDetachHelp = "  ~.  detach from console\r\n"
+
+const consoleHelp = "  ~p  power off (ACPI shutdown)\r\n" +
+	"  ~r  reset\r\n" +
+	"  ~~  send ~\r\n" +
+	"  ~?  this help\r\n"
+
+var errDetached = errors.New("Detached")
+
+// Poweroff sends ACPI shutdown request to the running VM, which won't
+// be restarted. If the VM is waiting to be restarted, it just stays
+// down.
+func (vm *VM) Poweroff() error {
+	if pid := vm.BhyvePid(); pid != 0 {
+		vm.requestStop()
+		return run(vm.ex, nil, os.Stdout, "kill", "-TERM", strconv.Itoa(pid))
+	} else if vm.runnerPid() != 0 {
+		vm.requestStop()
+		return nil
+	}
+	return ErrNotRunning
+}
+
+// Reset resets the running VM.
+func (vm *VM) Reset() error {
+	if !vm.Exists() {
+		return ErrNotRunning
+	}
+	return vm.RunBhyvectl("--force-reset")
+}
+
+// consoleMessage prints message on a terminal that may be in raw mode.
+func consoleMessage(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "\r\n["+format+"]\r\n", args...)
+}
+
+// consoleEscapes passes input through to the console, handling escape
+// sequences: ~ followed by a command character, at beginning of line.
+// A VM running in foreground stops with the terminal, so its console
+// can't be detached from.
+type consoleEscapes struct {
+	vm          *VM
+	console     io.Writer
+	foreground  bool
+	midLine     bool
+	afterEscape bool
+}
+
+func (e *consoleEscapes) copy(in io.Reader) error {
+	buf := make([]byte, 1024)
+	for {
+		n, err := in.Read(buf)
+		for _, b := range buf[:n] {
+			if err := e.input(b); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *consoleEscapes) input(b byte) error {
+	var out []byte
+	switch {
+	case e.afterEscape:
+		e.afterEscape = false
+		switch b {
+		case '.':
+			if !e.foreground {
+				return errDetached
+			}
+			consoleMessage("Can't detach from %s running in foreground, use run -d to run it in background", e.vm.Name)
+		case 'p':
+			consoleMessage("Powering off %s", e.vm.Name)
+			if err := e.vm.Poweroff(); err != nil {
+				consoleMessage("Error: %s", err)
+			}
+		case 'r':
+			consoleMessage("Resetting %s", e.vm.Name)
+			if err := e.vm.Reset(); err != nil {
+				consoleMessage("Error: %s", err)
+			}
+		case '?':
+			fmt.Fprint(os.Stderr, "\r\nConsole escapes (at beginning of line):\r\n")
+			if !e.foreground {
+				fmt.Fprint(os.Stderr, consoleDetachHelp)
+			}
+			fmt.Fprint(os.Stderr, consoleHelp)
+		case '~':
+			out = []byte{'~'}
+		default:
+			out = []byte{'~', b}
+		}
+	case b == '~' && !e.midLine:
+		e.afterEscape = true
+		return nil
+	default:
+		out = []byte{b}
+	}
+	if len(out) == 0 {
+		return nil // commands leave the input at beginning of line
+	}
+	e.midLine = b != '\r' && b != '\n'
+	_, err := e.console.Write(out)
+	return err
+}
+
+// openStdin returns a copy of stdin that can be closed while it's being
+// read, which stops the read, so that input typed after the console is
+// closed is not swallowed. Stdin is in non-blocking mode, so that it can
+// be polled, until restore is called.
+func openStdin() (*os.File, func(), error) {
+	fd, err := syscall.Dup(syscall.Stdin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, nil, err
+	}
+	in := os.NewFile(uintptr(fd), "stdin")
+	return in, func() {
+		in.Close()
+		syscall.SetNonblock(syscall.Stdin, false)
+	}, nil
+}
+
+// AttachConsole connects the terminal, in raw mode, to console of the VM
+// running in background or in another terminal, until the VM stops or
+// user detaches with ~. escape. The console of a VM run with
+// RunAttached can't be detached from.
+func (vm *VM) AttachConsole() error {
+	conn, err := net.Dial("unix", vm.ConsoleSocket())
+	if err != nil {
+		if vm.runnerPid() == 0 && !vm.Exists() {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("Can't connect to console of %s: %s", vm.Name, err)
+	}
+	defer conn.Close()
+
+	if vm.attached {
+		cli.Info("Attaching to console of " + vm.Name + ", type ~? for help")
+	} else {
+		cli.Info("Attaching to console of " + vm.Name + ", type ~. to detach, ~? for help")
+	}
+	in, closeIn, err := openStdin()
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+	if restore, err := makeRaw(uintptr(syscall.Stdin)); err != nil {
+		cli.Debugf("Not using raw mode: %s", err)
+	} else {
+		defer restore()
+	}
+
+	detached := make(chan error, 1)
+	go func() {
+		esc := &consoleEscapes{vm: vm, console: conn, foreground: vm.attached}
+		err := esc.copy(in)
+		detached <- err
+		if err == errDetached {
+			conn.Close()
+		}
+	}()
+
+	_, copyErr := io.Copy(os.Stdout, conn)
+	select {
+	case err := <-detached:
+		if err == errDetached {
+			consoleMessage("Detached from %s, it keeps running", vm.Name)
+			return nil
+		}
+	default:
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	consoleMessage("Console of %s closed", vm.Name)
+	return nil
+}
+
+// RunAttached runs the VM like Run, with the console proxied to the
+// terminal, where it can be detached from and attached to again with
+// AttachConsole.
+func (vm *VM) RunAttached() error {
+	vm.console = vm.nmdmPath("A")
+	vm.attached = true
+	return vm.Run()
+}
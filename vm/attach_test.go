@@ -0,0 +1,83 @@
+package vm
+
+import "bytes"
+import "io"
+import "os"
+import "strings"
+import "testing"
+
+func TestConsoleEscapes(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+		err     error
+	}{
+		{"~.", "", errDetached},
+		{"ls\r~.", "ls\r", errDetached},
+		{"ls\n~.", "ls\n", errDetached},
+		{"ls ~.\r", "ls ~.\r", io.EOF},
+		{"~~.", "~.", io.EOF},
+		{"~~~.", "~~.", io.EOF},
+		{"~x", "~x", io.EOF},
+		{"~?", "", io.EOF},
+		{"~?~.", "", errDetached},
+		{"\r~~\r~.", "\r~\r", errDetached},
+	} {
+		vm, _ := testVM(t)
+		var out bytes.Buffer
+		esc := &consoleEscapes{vm: vm, console: &out}
+		if err := esc.copy(strings.NewReader(tc.in)); err != tc.err {
+			t.Errorf("%#v: expected %v, got %v", tc.in, tc.err, err)
+		}
+		if out.String() != tc.out {
+			t.Errorf("%#v: sent %#v to console, expected %#v", tc.in, out.String(), tc.out)
+		}
+	}
+}
+
+// Console of a VM running in foreground can't be detached from.
+func TestConsoleEscapesForeground(t *testing.T) {
+	vm, _ := testVM(t)
+	var out bytes.Buffer
+	esc := &consoleEscapes{vm: vm, console: &out, foreground: true}
+	if err := esc.copy(strings.NewReader("~.ls")); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+	if out.String() != "ls" {
+		t.Errorf("Sent %#v to console, expected \"ls\"", out.String())
+	}
+}
+
+// ~p keeps a VM waiting to be restarted down.
+func TestConsoleEscapePoweroff(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.state = &State{Runner: os.Getpid()}
+	vm.saveState()
+
+	var out bytes.Buffer
+	esc := &consoleEscapes{vm: vm, console: &out}
+	if err := esc.copy(strings.NewReader("~p")); err != io.EOF {
+		t.Error(err)
+	}
+	if !vm.stopRequested() {
+		t.Error("~p didn't request stop")
+	}
+}
+
+func TestLoaderConsole(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.console = "/dev/nmdm-test-A"
+	vm.Properties["loader"] = "bhyveload"
+	if err := vm.RunBhyveload(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.RunBhyveload(strings.NewReader("boot\n")); err != nil {
+		t.Fatal(err)
+	}
+	calls := callsOf(ex, "bhyveload")
+	expectCommands(t, ex, "bhyveload",
+		"bhyveload -m 1024 -d /dev/zvol/tank/test -c /dev/nmdm-test-A test",
+		"bhyveload -m 1024 -d /dev/zvol/tank/test test")
+	if calls[0].Stdin != nil || calls[1].Stdin == nil {
+		t.Error("Scripted input is not piped to the loader's stdin")
+	}
+}
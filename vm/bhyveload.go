@@ -0,0 +1,55 @@
+package vm
+
+import "io"
+import "os"
+import "sort"
+import "strings"
+
+const bhyveloadEnvPrefix = "bhyveload:env:"
+
+// bhyveloadEnv returns loader environment set with bhyveload:env:KEY
+// properties, as KEY=VALUE pairs sorted by KEY.
+func (vm *VM) bhyveloadEnv() []string {
+	var env []string
+	for name, value := range vm.Properties {
+		if strings.HasPrefix(name, bhyveloadEnvPrefix) && len(name) > len(bhyveloadEnvPrefix) {
+			env = append(env, name[len(bhyveloadEnvPrefix):]+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// RunBhyveload loads FreeBSD kernel from the VM's boot disk with
+// bhyveload.
+func (vm *VM) RunBhyveload(in io.Reader) error {
+	disks, err := vm.Disks()
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"-m", vm.memory(),
+		"-d", disks[0].Path}
+	if vm.wiredMemory() {
+		args = append(args, "-S")
+	}
+	for _, kv := range vm.bhyveloadEnv() {
+		args = append(args, "-e", kv)
+	}
+	stdin, console := vm.loaderConsole(in)
+	if console != "" {
+		args = append(args, "-c", console)
+	}
+	args = append(args, vm.Name)
+
+	return run(vm.ex, stdin, os.Stdout, "bhyveload", args...)
+}
+
+func (vm *VM) loadBhyveload() error {
+	if in, err := vm.loaderInput("bhyveload:in"); err != nil {
+		return err
+	} else {
+		return vm.RunBhyveload(in)
+	}
+}
@@ -0,0 +1,22 @@
+package vm
+
+import "testing"
+
+func TestLoadBhyveload(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["loader"] = "bhyveload"
+	vm.Properties["bhyveload:env:boot_verbose"] = "YES"
+	vm.Properties["bhyveload:env:console"] = "comconsole"
+	vm.Properties["disk1"] = "/dev/null,ahci-hd"
+	vm.Properties["mem:wired"] = "yes"
+
+	if err := vm.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectCommands(t, ex, "bhyveload",
+		"bhyveload -m 1024 -d /dev/zvol/tank/test -S -e boot_verbose=YES -e console=comconsole test")
+	expectArgs(t, vm, "-c 1 -m 1024 -A -P -H -l com1,stdio -S"+
+		" -s 0:0,hostbridge -s 1:0,lpc -s 2:0,virtio-blk,/dev/zvol/tank/test"+
+		" -s 3:0,virtio-net,tap0,mac="+vm.MAC()+" -s 4:0,ahci-hd,/dev/null test")
+}
@@ -0,0 +1,80 @@
+package vm
+
+import "os"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+// copyProperties returns properties of another VM that can be copied to
+// a new one. Unique properties are left out, and the rest are passed
+// through their Clone; properties naming the host's files are also left
+// out if the other VM comes from another host.
+func copyProperties(props map[string]string, otherHost bool) map[string]string {
+	copied := make(map[string]string)
+	for prop, value := range props {
+		spec := LookupProperty(prop)
+		switch {
+		case spec == nil || spec.Unique:
+			continue
+		case spec.Host && otherHost:
+			cli.Infof("Not copying %s, it refers to the source host", prop)
+			continue
+		case spec.Clone != nil:
+			var ok bool
+			if value, ok = spec.Clone(prop, value); !ok {
+				cli.Infof("Not copying %s, it would be shared with the source VM", prop)
+				continue
+			}
+		}
+		copied[prop] = value
+	}
+	return copied
+}
+
+// Clone creates a new VM, named name, from a snapshot of this VM, with
+// volume cloned from the snapshot. Properties recorded on the snapshot
+// (or, if there are none, properties set on this VM) are copied to the
+// new VM, except for the ones that have to be unique; overrides take
+// precedence over the copied properties.
+func (vm *VM) Clone(snapshot, name, volume string, overrides map[string]string) (*VM, error) {
+	clone := NewVM(vm.ex, name, volume)
+	if err := clone.checkNew(); err != nil {
+		return nil, err
+	}
+
+	props, err := vm.snapshotProperties(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if len(props) == 0 {
+		for _, prop := range vm.localProperties() {
+			props[prop] = vm.Properties[prop]
+		}
+	}
+
+	for prop := range overrides {
+		delete(props, prop)
+	}
+	clone.Properties = copyProperties(props, false)
+	for prop, value := range overrides {
+		clone.Properties[prop] = value
+	}
+
+	args := []string{"clone"}
+	if opts, err := clone.propertyOptions(); err != nil {
+		return nil, err
+	} else {
+		args = append(args, opts...)
+	}
+
+	args = append(args, vm.snapshotPath(snapshot), clone.Volume)
+	if err := run(vm.ex, nil, os.Stdout, "zfs", args...); err != nil {
+		return nil, err
+	}
+	if !vm.filesystem {
+		// A filesystem clone already has them
+		if err := copyFile(vm.uefiVarsPath(snapshot), clone.uefiVarsPath("")); err != nil {
+			return nil, err
+		}
+	}
+	return clone, nil
+}
@@ -0,0 +1,49 @@
+package vm
+
+import "testing"
+
+func TestClone(t *testing.T) {
+	vm, ex := testVM(t)
+	ex.On("zfs", "get", "-H", "-s", "local", "-o", "property,value", "all", "tank/test@base").
+		Output("bhyve:name\ttest\nbhyve:cpus\t2\nbhyve:net1\tbridge1,e1000,mac=02:00:00:00:00:01\nbhyve:vnc:port\t5901\n" +
+			"bhyve:disk0\t/dev/null,ahci-hd\nbhyve:disk1\t/dev/null\nbhyve:disk2\t/dev/null\n")
+
+	clone, err := vm.Clone("base", "copy", "tank/copy", map[string]string{"mem": "2G", "disk2": "/dev/zero"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.Name != "copy" || clone.Volume != "tank/copy" {
+		t.Errorf("Unexpected clone: %s on %s", clone.Name, clone.Volume)
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name",
+		"zfs get -H -s local -o property,value all tank/test@base",
+		"zfs clone -o bhyve:name=copy -o bhyve:cpus=2 -o bhyve:disk0=,ahci-hd -o bhyve:disk2=/dev/zero -o bhyve:mem=2G -o bhyve:net1=bridge1,e1000 tank/test@base tank/copy")
+}
+
+// Properties of a snapshot not taken with Snapshot are the VM's current
+// ones.
+func TestCloneLocalProperties(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["cpus"] = "2"
+	vm.Properties["vnc:port"] = "5901"
+
+	if _, err := vm.Clone("base", "copy", "tank/copy", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name",
+		"zfs get -H -s local -o property,value all tank/test@base",
+		"zfs clone -o bhyve:name=copy -o bhyve:bridge=bktest0 -o bhyve:cpus=2 tank/test@base tank/copy")
+}
+
+func TestCloneExisting(t *testing.T) {
+	vm, ex := testVM(t)
+	ex.On("zfs", "get", "-H", "-t").Output("copy\ttank/copy\n")
+
+	if _, err := vm.Clone("base", "copy", "tank/copy", nil); err != ErrVMExists {
+		t.Errorf("Expected ErrVMExists, got %v", err)
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name")
+}
@@ -0,0 +1,215 @@
+package vm
+
+import "bufio"
+import "bytes"
+import "errors"
+import "fmt"
+import "io"
+import "os"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+// How often ConsoleLog checks for new output when following the log
+var logPollInterval = 500 * time.Millisecond
+
+// consoleLog writes console output to a file, prefixing each line with
+// a timestamp. When the file grows over maxSize, it is rotated, and up
+// to keep old files are kept as path.1, path.2, ...
+type consoleLog struct {
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+	midLine bool
+	failed  bool
+	mu      sync.Mutex
+}
+
+func openConsoleLog(path string, maxSize int64, keep int) (*consoleLog, error) {
+	l := &consoleLog{path: path, maxSize: maxSize, keep: keep}
+	return l, l.open()
+}
+
+func (l *consoleLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	if fi, err := f.Stat(); err != nil {
+		f.Close()
+		return err
+	} else {
+		l.f, l.size = f, fi.Size()
+	}
+	return nil
+}
+
+func (l *consoleLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	for i := l.keep - 1; i > 0; i-- {
+		os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Write never fails, so that it can be used along the terminal in a
+// MultiWriter; errors are reported once.
+func (l *consoleLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var buf bytes.Buffer
+	for rest := p; len(rest) > 0; {
+		if !l.midLine {
+			buf.WriteString(time.Now().Format(time.RFC3339) + " ")
+			l.midLine = true
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			buf.Write(rest)
+			break
+		}
+		buf.Write(rest[:i+1])
+		rest = rest[i+1:]
+		l.midLine = false
+	}
+
+	n, err := l.f.Write(buf.Bytes())
+	l.size += int64(n)
+	// Rotate at end of line, unless the line is very long
+	if err == nil && (l.size >= l.maxSize && !l.midLine || l.size >= 2*l.maxSize) {
+		err = l.rotate()
+	}
+	if err != nil && !l.failed {
+		cli.Error(fmt.Errorf("Console log: %s", err))
+		l.failed = true
+	}
+	return len(p), nil
+}
+
+func (l *consoleLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// ConsoleLogPath returns path of the VM's console log.
+func (vm *VM) ConsoleLogPath() string {
+	return vm.dataPath("console.log")
+}
+
+// HasConsoleLog returns true if the VM's console output is logged.
+func (vm *VM) HasConsoleLog() bool {
+	hasLog, _ := ParseBool(vm.Property("console:log"))
+	return hasLog
+}
+
+// openConsoleLog opens the VM's console log, or returns nil if the
+// console is not logged.
+func (vm *VM) openConsoleLog() (*consoleLog, error) {
+	if !vm.HasConsoleLog() {
+		return nil, nil
+	}
+	if err := vm.ensureDataDir(); err != nil {
+		return nil, err
+	}
+	size, _ := ParseSize(vm.Property("console:log_size"))
+	keep, _ := strconv.Atoi(vm.Property("console:log_files"))
+	return openConsoleLog(vm.ConsoleLogPath(), size<<20, keep)
+}
+
+// ConsoleLog writes the VM's console log to w: its last lines lines, or
+// all of it, including rotated files, if lines is zero. If follow is
+// true, it then keeps writing new output as it is logged, and never
+// returns unless there's an error.
+func (vm *VM) ConsoleLog(w io.Writer, lines int, follow bool) error {
+	path := vm.ConsoleLogPath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return errors.New("No console log for " + vm.Name + " (is console:log on?)")
+	}
+
+	var logs []string
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path + "." + strconv.Itoa(i)); err != nil {
+			break
+		}
+		logs = append([]string{path + "." + strconv.Itoa(i)}, logs...)
+	}
+	logs = append(logs, path)
+
+	var tail []string
+	for _, log := range logs {
+		f, err := os.Open(log)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			tail = append(tail, scanner.Text())
+			if lines > 0 && len(tail) > lines {
+				tail = tail[1:]
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	if len(tail) > 0 {
+		if _, err := io.WriteString(w, strings.Join(tail, "\n")+"\n"); err != nil {
+			return err
+		}
+	}
+
+	if follow {
+		return followLog(w, path)
+	}
+	return nil
+}
+
+// followLog writes new contents of file at path to w, reopening it when
+// it's rotated.
+func followLog(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	for {
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+		time.Sleep(logPollInterval)
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue // in the middle of rotation
+		}
+		if ofi, err := f.Stat(); err != nil {
+			return err
+		} else if !os.SameFile(fi, ofi) {
+			// Rotated: finish the old file, continue with the new one
+			if _, err := io.Copy(w, f); err != nil {
+				return err
+			}
+			f.Close()
+			if f, err = os.Open(path); err != nil {
+				return err
+			}
+		}
+	}
+}
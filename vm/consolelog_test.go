@@ -0,0 +1,111 @@
+package vm
+
+import "bytes"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "regexp"
+import "strings"
+import "testing"
+import "time"
+
+var rxLogLine = regexp.MustCompile(`^\d{4}-\d\d-\d\dT\S+ `)
+
+// readLog returns lines of a log file; last one is incomplete, unless
+// it's empty.
+func readLog(t *testing.T, path string) []string {
+	t.Helper()
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(buf), "\n")
+}
+
+func TestConsoleLogRotation(t *testing.T) {
+	// Log is rotated after every third line; first three are dropped
+	lineSize := int64(len(time.Now().Format(time.RFC3339))) + 27
+	path := filepath.Join(t.TempDir(), "console.log")
+	l, err := openConsoleLog(path, 2*lineSize+1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		l.Write([]byte("line "))
+		l.Write([]byte(strings.Repeat("x", 20) + "\n"))
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("More rotated logs kept than console:log_files: %v", err)
+	}
+	for _, log := range []string{path + ".2", path + ".1"} {
+		lines := readLog(t, log)
+		if len(lines) != 4 || lines[3] != "" {
+			t.Errorf("%s: unexpected lines %#v", log, lines)
+		}
+		for _, line := range lines[:len(lines)-1] {
+			if !rxLogLine.MatchString(line) || !strings.HasSuffix(line, " line xxxxxxxxxxxxxxxxxxxx") {
+				t.Errorf("%s: unexpected line %#v", log, line)
+			}
+		}
+	}
+
+	// Not rotated in the middle of a line, unless it's very long
+	l.Write([]byte(strings.Repeat("y", 60)))
+	if lines := readLog(t, path); len(lines) != 2 || lines[1][len(lines[1])-60:] != strings.Repeat("y", 60) {
+		t.Errorf("Unexpected current log: %#v", lines)
+	}
+	l.Write([]byte(strings.Repeat("y", 100)))
+	if lines := readLog(t, path+".1"); len(lines) != 2 || !strings.HasSuffix(lines[1], strings.Repeat("y", 160)) {
+		t.Errorf("Very long line not rotated: %#v", lines)
+	}
+}
+
+func TestConsoleLog(t *testing.T) {
+	vm, _ := testVM(t)
+	var buf bytes.Buffer
+	if err := vm.ConsoleLog(&buf, 0, false); err == nil {
+		t.Error("Showed nonexistent log")
+	}
+
+	if err := vm.ensureDataDir(); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{".2": "a\nb\n", ".1": "c\n", "": "d\ne\n"} {
+		if err := ioutil.WriteFile(vm.ConsoleLogPath()+name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for lines, expected := range map[int]string{0: "a\nb\nc\nd\ne\n", 3: "c\nd\ne\n", 10: "a\nb\nc\nd\ne\n"} {
+		buf.Reset()
+		if err := vm.ConsoleLog(&buf, lines, false); err != nil {
+			t.Error(err)
+		} else if buf.String() != expected {
+			t.Errorf("Last %d lines: %#v, expected %#v", lines, buf.String(), expected)
+		}
+	}
+}
+
+// Output of a VM on stdio is logged also when it's loaded before Run,
+// as the packer builder does.
+func TestConsoleLogLoadedVM(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["console:log"] = "yes"
+	vm.Properties["restart"] = "never"
+	ex.On("bhyve").Do(func(c *Cmd) error {
+		io.WriteString(c.Stdout, "Booting\n")
+		return &ExitError{Path: "bhyve", Status: int(VMPoweroff)}
+	})
+
+	if err := vm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := readLog(t, vm.ConsoleLogPath()); len(lines) != 2 || !strings.HasSuffix(lines[0], " Booting") {
+		t.Errorf("Console log: %#v", lines)
+	}
+}
@@ -0,0 +1,126 @@
+package vm
+
+import "fmt"
+import "strconv"
+import "strings"
+
+// CPUTopology is the VM's virtual CPU configuration.
+type CPUTopology struct {
+	CPUs    int      `json:"cpus"`
+	Sockets int      `json:"sockets,omitempty"`
+	Cores   int      `json:"cores,omitempty"`
+	Threads int      `json:"threads,omitempty"`
+	Pinning []string `json:"pinning,omitempty"`
+}
+
+// ParsePinning parses value of the cpu:pin property: VCPU:HOSTCPU pairs
+// separated by spaces or commas.
+func ParsePinning(value string) ([][2]int, error) {
+	var pins [][2]int
+	for _, item := range splitList(value) {
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid CPU pinning %#v, expected VCPU:HOSTCPU", item)
+		}
+		var pin [2]int
+		for i, part := range parts {
+			if n, err := strconv.Atoi(part); err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid CPU pinning %#v, expected VCPU:HOSTCPU", item)
+			} else {
+				pin[i] = n
+			}
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+func checkPinning(ex Executor, value string) error {
+	_, err := ParsePinning(value)
+	return err
+}
+
+func checkPinningHost(ex Executor, value string) error {
+	pins, _ := ParsePinning(value)
+	ncpu, err := HostCPUs(ex)
+	if err != nil {
+		return err
+	}
+	for _, pin := range pins {
+		if pin[1] >= ncpu {
+			return fmt.Errorf("Can't pin to CPU %d, host has only %d CPUs", pin[1], ncpu)
+		}
+	}
+	return nil
+}
+
+// CPUTopology returns the VM's CPU configuration. Sockets, cores and
+// threads are zero unless the topology is set.
+func (vm *VM) CPUTopology() *CPUTopology {
+	topo := &CPUTopology{}
+	topo.CPUs, _ = strconv.Atoi(vm.Property("cpus"))
+	if vm.Property("cpu:sockets") != "" || vm.Property("cpu:cores") != "" || vm.Property("cpu:threads") != "" {
+		topo.Sockets, topo.Cores, topo.Threads = 1, 1, 1
+		if n, err := strconv.Atoi(vm.Property("cpu:sockets")); err == nil {
+			topo.Sockets = n
+		}
+		if n, err := strconv.Atoi(vm.Property("cpu:cores")); err == nil {
+			topo.Cores = n
+		}
+		if n, err := strconv.Atoi(vm.Property("cpu:threads")); err == nil {
+			topo.Threads = n
+		}
+	}
+	if pins, err := ParsePinning(vm.Property("cpu:pin")); err == nil {
+		for _, pin := range pins {
+			topo.Pinning = append(topo.Pinning, fmt.Sprintf("%d:%d", pin[0], pin[1]))
+		}
+	}
+	return topo
+}
+
+// checkCPUs checks that the CPU topology and pinning match the number
+// of the VM's CPUs.
+func (vm *VM) checkCPUs() []string {
+	var errs []string
+	topo := vm.CPUTopology()
+	if topo.Sockets != 0 && topo.Sockets*topo.Cores*topo.Threads != topo.CPUs {
+		errs = append(errs, fmt.Sprintf("cpu:sockets: %d sockets * %d cores * %d threads is not %d CPUs",
+			topo.Sockets, topo.Cores, topo.Threads, topo.CPUs))
+	}
+	pins, _ := ParsePinning(vm.Property("cpu:pin"))
+	pinned := make(map[int]bool)
+	for _, pin := range pins {
+		switch {
+		case pin[0] >= topo.CPUs:
+			errs = append(errs, fmt.Sprintf("cpu:pin: VM has no CPU %d", pin[0]))
+		case pinned[pin[0]]:
+			errs = append(errs, fmt.Sprintf("cpu:pin: CPU %d is pinned twice", pin[0]))
+		}
+		pinned[pin[0]] = true
+	}
+	return errs
+}
+
+// cpuArgs returns bhyve's switches for the VM's CPUs.
+func (vm *VM) cpuArgs() []string {
+	topo := vm.CPUTopology()
+	cpus := strconv.Itoa(topo.CPUs)
+	if topo.Sockets != 0 {
+		cpus = fmt.Sprintf("cpus=%d,sockets=%d,cores=%d,threads=%d", topo.CPUs, topo.Sockets, topo.Cores, topo.Threads)
+	}
+	args := []string{"-c", cpus}
+	for _, pin := range topo.Pinning {
+		args = append(args, "-p", pin)
+	}
+	for _, sw := range []struct{ property, arg string }{
+		{"rtc:utc", "-u"},
+		{"cpu:ignore_msrs", "-w"},
+		{"cpu:x2apic", "-x"},
+	} {
+		if on, _ := ParseBool(vm.Property(sw.property)); on {
+			args = append(args, sw.arg)
+		}
+	}
+	return args
+}
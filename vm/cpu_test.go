@@ -0,0 +1,53 @@
+package vm
+
+import "reflect"
+import "strings"
+import "testing"
+
+func TestCPUArgs(t *testing.T) {
+	vm, _ := testVM(t)
+	if args := vm.cpuArgs(); !reflect.DeepEqual(args, []string{"-c", "1"}) {
+		t.Errorf("Default CPU args: %#v", args)
+	}
+
+	vm.Properties["cpus"] = "4"
+	vm.Properties["cpu:sockets"] = "2"
+	vm.Properties["cpu:cores"] = "2"
+	vm.Properties["cpu:pin"] = "0:1,1:2"
+	vm.Properties["cpu:ignore_msrs"] = "yes"
+	vm.Properties["cpu:x2apic"] = "yes"
+	vm.Properties["rtc:utc"] = "yes"
+	if args, expected := strings.Join(vm.cpuArgs(), " "),
+		"-c cpus=4,sockets=2,cores=2,threads=1 -p 0:1 -p 1:2 -u -w -x"; args != expected {
+		t.Errorf("CPU args: %#v, expected %#v", args, expected)
+	}
+}
+
+func TestCheckCPUs(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["cpus"] = "2"
+	vm.Properties["cpu:sockets"] = "2"
+	vm.Properties["cpu:cores"] = "2"
+	vm.Properties["cpu:pin"] = "0:0 0:1 2:3"
+
+	if errs, expected := vm.checkCPUs(), []string{
+		"cpu:sockets: 2 sockets * 2 cores * 1 threads is not 2 CPUs",
+		"cpu:pin: CPU 0 is pinned twice",
+		"cpu:pin: VM has no CPU 2",
+	}; !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Errors:\n  got:      %#v\n  expected: %#v", errs, expected)
+	}
+}
+
+// The test host has 4 CPUs.
+func TestCPUHostCheck(t *testing.T) {
+	vm, _ := testVM(t)
+	for _, prop := range []struct{ name, value string }{{"cpus", "5"}, {"cpu:pin", "0:4"}} {
+		if err := ValidateProperty(vm.ex, prop.name, prop.value); err == nil {
+			t.Errorf("Accepted %s=%s", prop.name, prop.value)
+		}
+	}
+	if err := ValidateProperty(vm.ex, "cpu:pin", "0:3"); err != nil {
+		t.Error(err)
+	}
+}
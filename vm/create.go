@@ -0,0 +1,84 @@
+package vm
+
+import "errors"
+import "fmt"
+import "os"
+import "sort"
+import "strings"
+
+var ErrVMExists = errors.New("VM already exists")
+
+// DefaultPool returns name of the first ZFS pool on the host.
+func DefaultPool(ex Executor) (string, error) {
+	if out, err := runStdout(ex, nil, "zpool", "list", "-H", "-o", "name"); err != nil {
+		return "", err
+	} else if pool := strings.SplitN(out, "\n", 2)[0]; pool == "" {
+		return "", errors.New("No ZFS pools found")
+	} else {
+		return pool, nil
+	}
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "/@# \t\n")
+}
+
+// checkNew verifies that the VM's name is valid, and that no other VM
+// has the same name.
+func (vm *VM) checkNew() error {
+	if !validName(vm.Name) {
+		return fmt.Errorf("Invalid VM name: %#v", vm.Name)
+	}
+
+	if vms, err := AllVMs(vm.ex); err != nil {
+		return err
+	} else {
+		for _, other := range vms {
+			if other.Name == vm.Name {
+				return ErrVMExists
+			}
+		}
+	}
+	return nil
+}
+
+// propertyOptions validates VM's properties, and returns "-o" switches
+// for zfs create or zfs clone that set them, and the VM's name.
+func (vm *VM) propertyOptions() ([]string, error) {
+	opts := []string{"-o", "bhyve:name=" + vm.Name}
+
+	props := make([]string, 0, len(vm.Properties))
+	for prop := range vm.Properties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+	for _, prop := range props {
+		if err := ValidateProperty(vm.ex, prop, vm.Properties[prop]); err != nil {
+			return nil, fmt.Errorf("%s: %s", prop, err)
+		}
+		opts = append(opts, "-o", "bhyve:"+prop+"="+vm.Properties[prop])
+	}
+	return opts, nil
+}
+
+// Create provisions the VM's ZFS volume, and stores the VM's name and
+// properties as bhyve:* user properties on it.
+func (vm *VM) Create(size string, sparse bool) error {
+	if err := vm.checkNew(); err != nil {
+		return err
+	}
+
+	args := []string{"create", "-V", size}
+	if sparse {
+		args = append(args, "-s")
+	}
+
+	if opts, err := vm.propertyOptions(); err != nil {
+		return err
+	} else {
+		args = append(args, opts...)
+	}
+
+	args = append(args, vm.Volume)
+	return run(vm.ex, nil, os.Stdout, "zfs", args...)
+}
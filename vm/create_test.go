@@ -0,0 +1,49 @@
+package vm
+
+import "testing"
+
+func TestCreate(t *testing.T) {
+	for _, tc := range []struct {
+		props    map[string]string
+		sparse   bool
+		existing string
+		fails    bool
+		create   string
+	}{
+		{props: map[string]string{"cpus": "2"}, sparse: true,
+			create: "zfs create -V 10G -s -o bhyve:name=test -o bhyve:bridge=bktest0 -o bhyve:cpus=2 tank/test"},
+		{props: map[string]string{"mem": "2G"},
+			create: "zfs create -V 10G -o bhyve:name=test -o bhyve:bridge=bktest0 -o bhyve:mem=2G tank/test"},
+		{props: map[string]string{"mem": "lots"}, fails: true},
+		{props: map[string]string{"foo": "bar"}, fails: true},
+		{existing: "test\ttank/other\n", fails: true},
+	} {
+		vm, ex := testVM(t)
+		for name, value := range tc.props {
+			vm.Properties[name] = value
+		}
+		if tc.existing != "" {
+			ex.On("zfs", "get", "-H", "-t").Output(tc.existing)
+		}
+
+		err := vm.Create("10G", tc.sparse)
+		if (err != nil) != tc.fails || (tc.existing != "" && err != ErrVMExists) {
+			t.Errorf("%v: error %v", tc.props, err)
+		}
+		expected := []string{"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name"}
+		if tc.create != "" {
+			expected = append(expected, tc.create)
+		}
+		expectCommands(t, ex, "zfs", expected...)
+	}
+}
+
+func TestCreateInvalidName(t *testing.T) {
+	for _, name := range []string{"", "a/b", "a@b", "a b"} {
+		_, ex := testVM(t)
+		if err := NewVM(ex, name, "tank/x").Create("10G", false); err == nil {
+			t.Errorf("Created VM named %#v", name)
+		}
+		expectCommands(t, ex, "zfs")
+	}
+}
@@ -0,0 +1,139 @@
+package vm
+
+import "fmt"
+import "os"
+import "path/filepath"
+import "sort"
+import "strconv"
+import "strings"
+
+var diskEmulations = []string{"virtio-blk", "ahci-hd", "nvme"}
+
+var diskOptions = []string{"nocache", "direct", "ro"}
+
+// Disk is a block device attached to the VM.
+type Disk struct {
+	Index     int      `json:"index"`
+	Path      string   `json:"path"`
+	Emulation string   `json:"emulation"`
+	Options   []string `json:"options,omitempty"`
+}
+
+// ParseDisk parses value of a disk property:
+// [PATH][,EMULATION][,OPTION...]. PATH not starting with a slash is a
+// ZFS volume name; empty PATH is left empty.
+func ParseDisk(value string) (*Disk, error) {
+	parts := strings.Split(value, ",")
+	disk := &Disk{Path: parts[0], Emulation: "virtio-blk"}
+	if disk.Path != "" && !filepath.IsAbs(disk.Path) {
+		disk.Path = filepath.Join("/dev/zvol", disk.Path)
+	}
+
+	for i, part := range parts[1:] {
+		switch {
+		case i == 0 && oneOf(part, diskEmulations):
+			disk.Emulation = part
+		case oneOf(part, diskOptions):
+			disk.Options = append(disk.Options, part)
+		case strings.HasPrefix(part, "sectorsize="):
+			if !validSectorSize(part[len("sectorsize="):]) {
+				return nil, fmt.Errorf("Invalid sector size %#v, expected LOGICAL[/PHYSICAL], powers of two from 512", part[len("sectorsize="):])
+			}
+			disk.Options = append(disk.Options, part)
+		default:
+			return nil, fmt.Errorf("Invalid disk option %#v", part)
+		}
+	}
+	return disk, nil
+}
+
+// validSectorSize checks sectorsize disk option: logical sector size,
+// optionally followed by physical one, which can't be smaller.
+func validSectorSize(value string) bool {
+	sizes := strings.SplitN(value, "/", 2)
+	prev := int64(512)
+	for _, size := range sizes {
+		n, err := strconv.ParseInt(size, 10, 32)
+		if err != nil || n < prev || n&(n-1) != 0 {
+			return false
+		}
+		prev = n
+	}
+	return true
+}
+
+func oneOf(s string, choices []string) bool {
+	for _, choice := range choices {
+		if s == choice {
+			return true
+		}
+	}
+	return false
+}
+
+func checkDisk(ex Executor, value string) error {
+	_, err := ParseDisk(value)
+	return err
+}
+
+func checkDiskHost(ex Executor, value string) error {
+	if disk, _ := ParseDisk(value); disk.Path != "" {
+		_, err := os.Stat(disk.Path)
+		return err
+	}
+	return nil
+}
+
+// cloneDisk keeps additional disks from being copied to clones, which
+// would attach the same disks as the source VM. The clone's boot disk
+// is its own cloned volume, so only emulation and options of disk0 are
+// copied.
+func cloneDisk(name, value string) (string, bool) {
+	if idx, _ := propertyIndex("disk", name); idx != 0 {
+		return "", false
+	}
+	parts := strings.Split(value, ",")
+	parts[0] = ""
+	return strings.Join(parts, ","), true
+}
+
+// Device returns bhyve's device specification of the disk (without the
+// slot).
+func (d *Disk) Device() string {
+	return strings.Join(append([]string{d.Emulation, d.Path}, d.Options...), ",")
+}
+
+// Disks returns the VM's disks: the boot disk as disk 0, followed by
+// the additional disks configured with disk<N> properties, ordered by N.
+// The boot disk is the VM's volume or image file, unless disk0 property
+// says otherwise.
+func (vm *VM) Disks() ([]*Disk, error) {
+	disks := []*Disk{{Path: vm.volumePath(), Emulation: "virtio-blk"}}
+	for name, value := range vm.Properties {
+		if idx, ok := propertyIndex("disk", name); ok {
+			disk, err := ParseDisk(value)
+			switch {
+			case err != nil:
+				return nil, fmt.Errorf("%s: %s", name, err)
+			case idx == 0:
+				if disk.Path == "" {
+					disk.Path = disks[0].Path
+				}
+				disks[0] = disk
+			case disk.Path == "":
+				return nil, fmt.Errorf("%s: no disk path", name)
+			default:
+				disk.Index = idx
+				disks = append(disks, disk)
+			}
+		}
+	}
+	sort.Sort(disksByIndex(disks))
+	return disks, nil
+}
+
+type disksByIndex []*Disk
+
+func (dd disksByIndex) Len() int           { return len(dd) }
+func (dd disksByIndex) Less(i, j int) bool { return dd[i].Index < dd[j].Index }
+func (dd disksByIndex) Swap(i, j int)      { dd[i], dd[j] = dd[j], dd[i] }
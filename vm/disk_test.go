@@ -0,0 +1,75 @@
+package vm
+
+import "io/ioutil"
+import "reflect"
+import "testing"
+
+func TestGrubDeviceMap(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["disk1"] = "/dev/null"
+	vm.Properties["disk3"] = "/dev/zero"
+
+	var deviceMap string
+	ex.On("grub-bhyve").Do(func(c *Cmd) error {
+		buf, err := ioutil.ReadFile(c.Args[3])
+		deviceMap = string(buf)
+		return err
+	})
+	if err := vm.RunGrub(nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "(hd0) /dev/zvol/tank/test\n(hd1) /dev/null\n(hd3) /dev/zero\n"; deviceMap != expected {
+		t.Errorf("Device map: %#v, expected %#v", deviceMap, expected)
+	}
+}
+
+func TestDisks(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["disk0"] = ",nvme"
+	vm.Properties["disk10"] = "/dev/null,ahci-hd,ro,sectorsize=4096"
+	vm.Properties["disk2"] = "tank/data"
+
+	disks, err := vm.Disks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var devices []string
+	for _, disk := range disks {
+		devices = append(devices, disk.Device())
+	}
+	if expected := []string{
+		"nvme,/dev/zvol/tank/test",
+		"virtio-blk,/dev/zvol/tank/data",
+		"ahci-hd,/dev/null,ro,sectorsize=4096",
+	}; !reflect.DeepEqual(devices, expected) {
+		t.Errorf("Disks: %#v, expected %#v", devices, expected)
+	}
+	if disks[2].Index != 10 {
+		t.Errorf("Index of disk10: %d", disks[2].Index)
+	}
+
+	vm.Properties["disk1"] = ",ahci-hd"
+	if _, err := vm.Disks(); err == nil {
+		t.Error("Accepted additional disk without a path")
+	}
+}
+
+func TestParseDiskSectorSize(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"sectorsize=512":       true,
+		"sectorsize=4096":      true,
+		"sectorsize=512/4096":  true,
+		"sectorsize=4096/4096": true,
+		"sectorsize=":          false,
+		"sectorsize=big":       false,
+		"sectorsize=256":       false,
+		"sectorsize=1000":      false,
+		"sectorsize=4096/512":  false,
+		"sectorsize=512/":      false,
+		"sectorsize=512/4096/": false,
+	} {
+		if err := checkDisk(nil, "/dev/null,"+value); (err == nil) != valid {
+			t.Errorf("%s: valid=%v, got error %v", value, valid, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package vm
+
+import "fmt"
+import "io"
+import "os"
+import "os/exec"
+import "syscall"
+
+// Cmd describes a single host command to be run by an Executor.
+type Cmd struct {
+	Path   string
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Detach bool // start in a new session, detached from terminal
+}
+
+func (c *Cmd) String() string {
+	return fmt.Sprintf("%s %v", c.Path, c.Args)
+}
+
+// Process is a command started by an Executor.
+type Process interface {
+	Pid() int
+	Signal(os.Signal) error
+	Wait() error
+}
+
+// Executor starts host commands. Everything the vm package runs on the
+// host (zfs, bhyve, grub-bhyve, ifconfig, ...) goes through an Executor,
+// so that it can be replaced with a FakeExecutor in tests.
+type Executor interface {
+	Start(*Cmd) (Process, error)
+}
+
+// ExitError is returned from Process.Wait when the command exits with
+// non-zero status or is killed by a signal.
+type ExitError struct {
+	Path   string
+	Status int
+	Signal syscall.Signal
+}
+
+func (e *ExitError) Signaled() bool {
+	return e.Signal != 0
+}
+
+func (e *ExitError) Error() string {
+	if e.Signaled() {
+		return fmt.Sprintf("%s: killed by %s", e.Path, e.Signal)
+	}
+	return fmt.Sprintf("%s: exit status %d", e.Path, e.Status)
+}
+
+// HostExecutor runs commands on the local host via os/exec.
+var HostExecutor Executor = hostExecutor{}
+
+type hostExecutor struct{}
+
+type hostProcess struct{ *exec.Cmd }
+
+func (hostExecutor) Start(c *Cmd) (Process, error) {
+	cmd := exec.Command(c.Path, c.Args...)
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	if c.Detach {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return hostProcess{cmd}, nil
+}
+
+func (p hostProcess) Pid() int {
+	return p.Process.Pid
+}
+
+func (p hostProcess) Signal(sig os.Signal) error {
+	return p.Process.Signal(sig)
+}
+
+func (p hostProcess) Wait() error {
+	switch err := p.Cmd.Wait(); err.(type) {
+	case *exec.ExitError:
+		ws := err.(*exec.ExitError).Sys().(syscall.WaitStatus)
+		if ws.Signaled() {
+			return &ExitError{Path: p.Path, Status: -1, Signal: ws.Signal()}
+		}
+		return &ExitError{Path: p.Path, Status: ws.ExitStatus()}
+	default:
+		return err
+	}
+}
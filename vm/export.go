@@ -0,0 +1,196 @@
+package vm
+
+import "bufio"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "path"
+import "path/filepath"
+import "sort"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+// Version of the export stream format
+const ExportFormat = 1
+
+const exportMagic = "bheekeeper-export\n"
+
+// ExportHeader precedes the zfs send stream in an exported VM. The
+// stream is incremental if Base is set. UEFIVars are the UEFI variables
+// saved with a volume VM's snapshot (a filesystem VM has them in the
+// stream).
+type ExportHeader struct {
+	Format     int               `json:"format"`
+	Name       string            `json:"name"`
+	Snapshot   string            `json:"snapshot"`
+	Base       string            `json:"base,omitempty"`
+	Properties map[string]string `json:"properties"`
+	UEFIVars   []byte            `json:"uefi_vars,omitempty"`
+}
+
+// Export writes a header and a zfs send stream of the VM's snapshot to
+// w. If snapshot is empty, a new one is taken. If base is not empty, an
+// incremental stream from base snapshot is sent.
+func (vm *VM) Export(w io.Writer, snapshot, base string) error {
+	if snapshot == "" {
+		if name, err := vm.Snapshot(""); err != nil {
+			return err
+		} else {
+			snapshot = name
+		}
+	}
+
+	props, err := vm.snapshotProperties(snapshot)
+	if err != nil {
+		return err
+	}
+	if len(props) == 0 {
+		for _, prop := range vm.localProperties() {
+			props[prop] = vm.Properties[prop]
+		}
+	}
+	delete(props, "name")
+
+	hdr := &ExportHeader{
+		Format:     ExportFormat,
+		Name:       vm.Name,
+		Snapshot:   snapshot,
+		Base:       base,
+		Properties: props,
+	}
+	if !vm.filesystem {
+		if vars, err := ioutil.ReadFile(vm.uefiVarsPath(snapshot)); err == nil {
+			hdr.UEFIVars = vars
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if buf, err := json.Marshal(hdr); err != nil {
+		return err
+	} else if _, err := fmt.Fprintf(w, "%s%s\n", exportMagic, buf); err != nil {
+		return err
+	}
+
+	args := []string{"send"}
+	if base != "" {
+		args = append(args, "-i", "@"+base)
+	}
+	return run(vm.ex, nil, w, "zfs", append(args, vm.snapshotPath(snapshot))...)
+}
+
+// ReadExportHeader reads export header from r, leaving r at the start of
+// the zfs send stream.
+func ReadExportHeader(r *bufio.Reader) (*ExportHeader, error) {
+	if magic, err := r.ReadString('\n'); err != nil || magic != exportMagic {
+		return nil, errors.New("Not a bheekeeper export stream")
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var hdr ExportHeader
+	if err := json.Unmarshal(line, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr.Format > ExportFormat {
+		return nil, fmt.Errorf("Unsupported export format version %d", hdr.Format)
+	}
+	return &hdr, nil
+}
+
+// Import receives an exported VM from r. The VM is renamed to name, if
+// not empty; a full stream is received into a new volume in pool, and
+// an incremental one into the existing VM's volume. Properties from the
+// export header are validated before receiving the stream, and set on
+// the VM like on a clone: unique ones, and ones that would share the
+// source VM's disks or files, are not copied. Unknown properties, and
+// ones not valid on this host (e.g. paths that don't exist here), are
+// skipped.
+func Import(ex Executor, r io.Reader, name, pool string) (*VM, error) {
+	rd := bufio.NewReader(r)
+	hdr, err := ReadExportHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = hdr.Name
+	}
+
+	var vm *VM
+	if hdr.Base != "" {
+		if vm, err = FindVM(ex, name); err != nil {
+			return nil, err
+		}
+		if vm.Exists() {
+			return nil, ErrRunning
+		}
+	} else {
+		if pool == "" {
+			if pool, err = DefaultPool(ex); err != nil {
+				return nil, err
+			}
+		}
+		vm = NewVM(ex, name, path.Join(pool, name))
+		if err := vm.checkNew(); err != nil {
+			return nil, err
+		}
+	}
+
+	known := make(map[string]string)
+	for prop, value := range hdr.Properties {
+		switch {
+		case prop == "name":
+			continue
+		case !IsKnownProperty(prop):
+			cli.Infof("Ignoring unknown property: %s", prop)
+			continue
+		}
+		spec := LookupProperty(prop)
+		if err := spec.checkKey(prop); err != nil {
+			return nil, fmt.Errorf("%s: %s", prop, err)
+		}
+		if err := spec.checkSyntax(ex, value); err != nil {
+			return nil, fmt.Errorf("%s: %s", prop, err)
+		}
+		known[prop] = value
+	}
+
+	copied := copyProperties(known, true)
+	props := make([]string, 0, len(copied))
+	for prop, value := range copied {
+		if err := LookupProperty(prop).checkHost(ex, value); err != nil {
+			cli.Infof("Ignoring %s, not valid on this host: %s", prop, err)
+			continue
+		}
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+
+	if err := run(ex, rd, os.Stdout, "zfs", "recv", vm.snapshotPath(hdr.Snapshot)); err != nil {
+		return nil, err
+	}
+
+	args := []string{"set", "bhyve:name=" + vm.Name}
+	for _, prop := range props {
+		args = append(args, "bhyve:"+prop+"="+copied[prop])
+	}
+	if err := run(ex, nil, os.Stdout, "zfs", append(args, vm.Volume)...); err != nil {
+		return nil, err
+	}
+	if err := vm.LoadProperties(); err != nil {
+		return nil, err
+	}
+
+	if len(hdr.UEFIVars) > 0 && !vm.filesystem {
+		if err := os.MkdirAll(filepath.Dir(vm.uefiVarsPath("")), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(vm.uefiVarsPath(""), hdr.UEFIVars, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return vm, nil
+}
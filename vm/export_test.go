@@ -0,0 +1,66 @@
+package vm
+
+import "strings"
+import "testing"
+
+func importStream(props string) *strings.Reader {
+	return strings.NewReader(exportMagic +
+		`{"format":1,"name":"test","snapshot":"base","properties":{` + props + "}}\n")
+}
+
+func TestImportProperties(t *testing.T) {
+	_, ex := testVM(t)
+	ex.On("zpool", "list").Output("tank\n")
+
+	if _, err := Import(ex, importStream(`"name":"evil","cpus":"2","foo:bar":"baz"`), "copy", ""); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name",
+		"zfs recv tank/copy@base",
+		"zfs set bhyve:name=copy bhyve:cpus=2 tank/copy",
+		"zfs get -H -o property,value,source all tank/copy")
+}
+
+func TestImportInvalidProperty(t *testing.T) {
+	_, ex := testVM(t)
+	ex.On("zpool", "list").Output("tank\n")
+
+	if _, err := Import(ex, importStream(`"loader":"lilo"`), "copy", ""); err == nil {
+		t.Error("Imported invalid property")
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name")
+}
+
+// Properties tied to the exporting host are skipped, rather than
+// keeping the VM from being imported.
+func TestImportHostProperties(t *testing.T) {
+	_, ex := testVM(t)
+	ex.On("zpool", "list").Output("tank\n")
+
+	if _, err := Import(ex, importStream(`"cdrom_iso":"/nonexistent.iso","disk1":"/nonexistent.img","cpus":"64","mem":"2G"`), "copy", ""); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name",
+		"zfs recv tank/copy@base",
+		"zfs set bhyve:name=copy bhyve:mem=2G tank/copy",
+		"zfs get -H -o property,value,source all tank/copy")
+}
+
+// Properties that would attach the source VM's disks, or collide with
+// other VMs, are not copied, even if they would be valid on this host.
+func TestImportForeignProperties(t *testing.T) {
+	_, ex := testVM(t)
+	ex.On("zpool", "list").Output("tank\n")
+
+	if _, err := Import(ex, importStream(`"disk0":"tank/other,ahci-hd","disk1":"/dev/null","vnc:port":"5901","share:data":"/tmp","com2":"file:/tmp/com2.log","cpus":"1"`), "copy", ""); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name",
+		"zfs recv tank/copy@base",
+		"zfs set bhyve:name=copy bhyve:cpus=1 bhyve:disk0=,ahci-hd tank/copy",
+		"zfs get -H -o property,value,source all tank/copy")
+}
@@ -0,0 +1,115 @@
+package vm
+
+import "io"
+import "os"
+import "strings"
+
+// FakeExecutor is a scriptable Executor for tests. It records every
+// command it is asked to start, and answers with the first scripted
+// FakeResponse whose command and leading arguments match. Commands
+// without a matching response succeed with no output.
+type FakeExecutor struct {
+	Calls     []*Cmd
+	responses []*FakeResponse
+	lastPid   int
+}
+
+// FakeResponse is a scripted answer of a FakeExecutor.
+type FakeResponse struct {
+	Path   string
+	Args   []string
+	Stdout string
+	Status int
+	Fn     func(*Cmd) error
+}
+
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{lastPid: 1000}
+}
+
+// On scripts a response for command, if it's called with args as
+// leading arguments.
+func (f *FakeExecutor) On(path string, args ...string) *FakeResponse {
+	r := &FakeResponse{Path: path, Args: args}
+	f.responses = append(f.responses, r)
+	return r
+}
+
+// Output sets what the command writes to its standard output.
+func (r *FakeResponse) Output(stdout string) *FakeResponse {
+	r.Stdout = stdout
+	return r
+}
+
+// Exit sets the command's exit status.
+func (r *FakeResponse) Exit(status int) *FakeResponse {
+	r.Status = status
+	return r
+}
+
+// Do makes the command call fn instead; fn's error is returned from Wait.
+func (r *FakeResponse) Do(fn func(*Cmd) error) *FakeResponse {
+	r.Fn = fn
+	return r
+}
+
+func (r *FakeResponse) matches(c *Cmd) bool {
+	if r.Path != c.Path || len(r.Args) > len(c.Args) {
+		return false
+	}
+	for i, arg := range r.Args {
+		if c.Args[i] != arg {
+			return false
+		}
+	}
+	return true
+}
+
+// Commands returns the recorded commands as space-joined strings.
+func (f *FakeExecutor) Commands() []string {
+	rv := make([]string, len(f.Calls))
+	for i, c := range f.Calls {
+		rv[i] = strings.Join(append([]string{c.Path}, c.Args...), " ")
+	}
+	return rv
+}
+
+func (f *FakeExecutor) Start(c *Cmd) (Process, error) {
+	f.Calls = append(f.Calls, c)
+	f.lastPid++
+	p := &fakeProcess{pid: f.lastPid}
+	for _, r := range f.responses {
+		if !r.matches(c) {
+			continue
+		}
+		if r.Fn != nil {
+			p.err = r.Fn(c)
+			return p, nil
+		}
+		if c.Stdout != nil {
+			io.WriteString(c.Stdout, r.Stdout)
+		}
+		if r.Status != 0 {
+			p.err = &ExitError{Path: c.Path, Status: r.Status}
+		}
+		return p, nil
+	}
+	return p, nil
+}
+
+type fakeProcess struct {
+	pid int
+	err error
+}
+
+func (p *fakeProcess) Pid() int {
+	return p.pid
+}
+
+func (p *fakeProcess) Signal(os.Signal) error {
+	return nil
+}
+
+func (p *fakeProcess) Wait() error {
+	return p.err
+}
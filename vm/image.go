@@ -0,0 +1,59 @@
+package vm
+
+import "fmt"
+import "os"
+import "path/filepath"
+
+// CreateImage provisions the VM's ZFS filesystem, with a sparse image
+// file of size as the boot disk, and stores the VM's name and properties
+// as bhyve:* user properties on it.
+func (vm *VM) CreateImage(size string) error {
+	if err := vm.checkNew(); err != nil {
+		return err
+	}
+
+	mb, err := ParseSize(size)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"create"}
+	if opts, err := vm.propertyOptions(); err != nil {
+		return err
+	} else {
+		args = append(args, opts...)
+	}
+
+	args = append(args, vm.Volume)
+	if err := run(vm.ex, nil, os.Stdout, "zfs", args...); err != nil {
+		return err
+	}
+
+	// Don't leave a VM without its boot disk behind
+	if err := vm.createImageFile(mb); err != nil {
+		run(vm.ex, nil, os.Stdout, "zfs", "destroy", vm.Volume)
+		return err
+	}
+	return nil
+}
+
+// createImageFile creates a sparse image file of mb megabytes as boot
+// disk of the VM's freshly created filesystem.
+func (vm *VM) createImageFile(mb int64) error {
+	if err := vm.LoadProperties(); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(vm.mountpoint) {
+		return fmt.Errorf("%s is not mounted (mountpoint=%s)", vm.Volume, vm.mountpoint)
+	}
+
+	img, err := os.OpenFile(vm.volumePath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := img.Truncate(mb << 20); err != nil {
+		img.Close()
+		return err
+	}
+	return img.Close()
+}
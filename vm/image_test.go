@@ -0,0 +1,55 @@
+package vm
+
+import "os"
+import "path/filepath"
+import "testing"
+
+func TestCreateImage(t *testing.T) {
+	vm, ex := testVM(t)
+	mountpoint := t.TempDir()
+	ex.On("zfs", "get", "-H", "-o", "property,value,source", "all", "tank/test").
+		Output("type\tfilesystem\t-\nmountpoint\t" + mountpoint + "\tdefault\nbhyve:name\ttest\tlocal\n")
+
+	if err := vm.CreateImage("1G"); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name",
+		"zfs create -o bhyve:name=test -o bhyve:bridge=bktest0 tank/test",
+		"zfs get -H -o property,value,source all tank/test")
+
+	if fi, err := os.Stat(filepath.Join(mountpoint, DiskImageName)); err != nil {
+		t.Error(err)
+	} else if fi.Size() != 1<<30 {
+		t.Errorf("Image size: %d", fi.Size())
+	}
+}
+
+func TestCreateImageUnmounted(t *testing.T) {
+	vm, ex := testVM(t)
+	ex.On("zfs", "get", "-H", "-o", "property,value,source", "all", "tank/test").
+		Output("type\tfilesystem\t-\nmountpoint\tnone\tlocal\n")
+
+	if err := vm.CreateImage("1G"); err == nil {
+		t.Error("CreateImage succeeded without a mountpoint")
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -t volume,filesystem -s local -o value,name bhyve:name",
+		"zfs create -o bhyve:name=test -o bhyve:bridge=bktest0 tank/test",
+		"zfs get -H -o property,value,source all tank/test",
+		"zfs destroy tank/test")
+}
+
+func TestCreateImageInvalidSize(t *testing.T) {
+	vm, ex := testVM(t)
+	for _, size := range []string{"", "lots", "512K"} {
+		if err := vm.CreateImage(size); err == nil {
+			t.Errorf("Created image of size %#v", size)
+		}
+	}
+	for _, cmd := range commandsOf(ex, "zfs") {
+		if cmd != "zfs get -H -t volume,filesystem -s local -o value,name bhyve:name" {
+			t.Errorf("Unexpected command: %s", cmd)
+		}
+	}
+}
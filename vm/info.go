@@ -0,0 +1,90 @@
+package vm
+
+// PropertyValue is an effective value of a property, with its source
+// (see PropertySource).
+type PropertyValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Info is a summary of the VM's configuration and runtime state.
+type Info struct {
+	Name          string                   `json:"name"`
+	Volume        string                   `json:"volume"`
+	MAC           string                   `json:"mac"`
+	Exists        bool                     `json:"exists"`
+	Pid           int                      `json:"pid,omitempty"`
+	Taps          []string                 `json:"taps,omitempty"`
+	CPU           *CPUTopology             `json:"cpu"`
+	WiredMemory   bool                     `json:"wired_memory,omitempty"`
+	Disks         []*Disk                  `json:"disks"`
+	NICs          []*NIC                   `json:"nics"`
+	SerialPorts   []*SerialPort            `json:"serial_ports,omitempty"`
+	ConsoleLog    string                   `json:"console_log,omitempty"`
+	PCI           []*PCIDevice             `json:"pci,omitempty"`
+	Shares        []*Share                 `json:"shares,omitempty"`
+	Channels      []*Channel               `json:"channels,omitempty"`
+	SupervisorPid int                      `json:"supervisor_pid,omitempty"`
+	VNC           string                   `json:"vnc,omitempty"`
+	State         *State                   `json:"state,omitempty"`
+	Properties    map[string]PropertyValue `json:"properties"`
+}
+
+// Shown instead of values of secret properties
+const HiddenValue = "********"
+
+// displayValue returns value of the named property for display: value
+// of a secret property is hidden.
+func displayValue(name, value string) string {
+	if spec := LookupProperty(name); spec != nil && spec.Secret && value != "" {
+		return HiddenValue
+	}
+	return value
+}
+
+// EffectiveProperty returns the property's value and source, for
+// display: value of a secret property is hidden.
+func (vm *VM) EffectiveProperty(name string) PropertyValue {
+	return PropertyValue{displayValue(name, vm.Property(name)), vm.PropertySource(name)}
+}
+
+// EffectiveProperties returns values of all properties that are set on
+// the VM or have a default, for display.
+func (vm *VM) EffectiveProperties() map[string]PropertyValue {
+	props := make(map[string]PropertyValue)
+	for _, name := range vm.PropertyNames() {
+		if prop := vm.EffectiveProperty(name); prop.Source != "" {
+			props[name] = prop
+		}
+	}
+	return props
+}
+
+func (vm *VM) Info() *Info {
+	info := &Info{
+		Name:       vm.Name,
+		Volume:     vm.Volume,
+		MAC:        vm.MAC(),
+		Exists:     vm.Exists(),
+		Properties: vm.EffectiveProperties(),
+	}
+	if info.Exists {
+		info.Pid = vm.BhyvePid()
+		info.Taps = vm.Taps(false)
+	}
+	info.CPU = vm.CPUTopology()
+	info.WiredMemory = vm.wiredMemory()
+	info.Disks, _ = vm.Disks()
+	info.NICs, _ = vm.NICs()
+	info.SerialPorts = vm.SerialPorts()
+	if vm.HasConsoleLog() {
+		info.ConsoleLog = vm.ConsoleLogPath()
+	}
+	info.PCI, _ = vm.PCIDevices()
+	info.Shares, _ = vm.Shares()
+	info.Channels, _ = vm.Channels()
+	info.SupervisorPid = vm.SupervisorPid()
+	info.VNC = vm.VNCAddress()
+	info.State = vm.State()
+	return info
+}
@@ -0,0 +1,64 @@
+package vm
+
+import "encoding/json"
+import "reflect"
+import "sort"
+import "testing"
+
+// JSON output of status, list (status without VM) and get commands, which
+// scripts depend on.
+func TestInfoJSON(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["cpus"] = "2"
+	vm.sources["cpus"] = "local"
+	vm.Properties["vnc:password"] = "secret"
+
+	infoKeys := []string{"cpu", "disks", "exists", "mac", "name", "nics", "pci", "properties", "volume"}
+	for _, tc := range []struct {
+		command string
+		v       interface{}
+		keys    []string
+	}{
+		{"status", vm.Info(), infoKeys},
+		{"list", []*Info{vm.Info()}, infoKeys},
+		{"get", map[string]PropertyValue{"cpus": vm.EffectiveProperty("cpus"), "vnc:password": vm.EffectiveProperty("vnc:password")}, []string{"cpus", "vnc:password"}},
+	} {
+		buf, err := json.Marshal(tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var obj map[string]interface{}
+		if tc.command == "list" {
+			var list []map[string]interface{}
+			if err := json.Unmarshal(buf, &list); err != nil || len(list) != 1 {
+				t.Fatalf("%s: %s %#v", tc.command, err, list)
+			}
+			obj = list[0]
+		} else if err := json.Unmarshal(buf, &obj); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("%s: keys %v, expected %v", tc.command, keys, tc.keys)
+		}
+		if tc.command == "get" {
+			if string(buf) != `{"cpus":{"value":"2","source":"local"},"vnc:password":{"value":"********","source":"local"}}` {
+				t.Errorf("get: %s", buf)
+			}
+			continue
+		}
+		if obj["name"] != "test" || obj["volume"] != "tank/test" || obj["exists"] != false {
+			t.Errorf("%s: %s", tc.command, buf)
+		}
+		if prop, _ := obj["properties"].(map[string]interface{})["cpus"].(map[string]interface{}); prop["value"] != "2" || prop["source"] != "local" {
+			t.Errorf("%s: cpus property %#v", tc.command, prop)
+		}
+		if cpu, _ := obj["cpu"].(map[string]interface{}); cpu["cpus"] != 2.0 {
+			t.Errorf("%s: cpu %#v", tc.command, cpu)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package vm
+
+import "crypto/md5"
+import "fmt"
+import "net"
+import "os"
+import "regexp"
+import "sort"
+import "strconv"
+import "strings"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+var nicModels = []string{"virtio-net", "e1000"}
+
+// NIC is a network interface of the VM, attached to a bridge through a
+// tap interface.
+type NIC struct {
+	Index  int    `json:"index"`
+	Bridge string `json:"bridge"`
+	Model  string `json:"model"`
+	MAC    string `json:"mac"`
+	VLAN   int    `json:"vlan,omitempty"`
+}
+
+// ParseNIC parses value of a net property:
+// [BRIDGE][,MODEL][,mac=MAC][,vlan=VLAN]. MAC is left empty if not
+// given explicitly, and so is BRIDGE.
+func ParseNIC(value string) (*NIC, error) {
+	parts := strings.Split(value, ",")
+	nic := &NIC{Bridge: parts[0], Model: "virtio-net"}
+	for i, part := range parts[1:] {
+		switch {
+		case i == 0 && oneOf(part, nicModels):
+			nic.Model = part
+		case strings.HasPrefix(part, "mac="):
+			if hw, err := net.ParseMAC(part[4:]); err != nil || len(hw) != 6 {
+				return nil, fmt.Errorf("Invalid MAC address %#v", part[4:])
+			} else {
+				nic.MAC = hw.String()
+			}
+		case strings.HasPrefix(part, "vlan="):
+			if vlan, err := strconv.Atoi(part[5:]); err != nil || vlan < 1 || vlan > 4094 {
+				return nil, fmt.Errorf("Invalid VLAN %#v", part[5:])
+			} else {
+				nic.VLAN = vlan
+			}
+		default:
+			return nil, fmt.Errorf("Invalid network interface option %#v", part)
+		}
+	}
+	return nic, nil
+}
+
+func checkNIC(ex Executor, value string) error {
+	_, err := ParseNIC(value)
+	return err
+}
+
+// cloneNIC drops fixed MAC address from a net property's value, so
+// that the clone gets its own.
+func cloneNIC(name, value string) (string, bool) {
+	parts := strings.Split(value, ",")
+	kept := parts[:1]
+	for _, part := range parts[1:] {
+		if !strings.HasPrefix(part, "mac=") {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, ","), true
+}
+
+// Device returns bhyve's device specification of the NIC attached to
+// tap (without the slot).
+func (nic *NIC) Device(tap string) string {
+	return nic.Model + "," + tap + ",mac=" + nic.MAC
+}
+
+// NICs returns the VM's network interfaces configured with net<N>
+// properties, ordered by N. A VM without any net<N> properties gets a
+// single virtio-net interface. Bridge defaults to the bridge property,
+// and MAC address to one derived from the VM's name and NIC's index.
+func (vm *VM) NICs() ([]*NIC, error) {
+	var nics []*NIC
+	for name, value := range vm.Properties {
+		if idx, ok := propertyIndex("net", name); ok {
+			if nic, err := ParseNIC(value); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			} else {
+				nic.Index = idx
+				nics = append(nics, nic)
+			}
+		}
+	}
+	if len(nics) == 0 {
+		nics = []*NIC{{Model: "virtio-net"}}
+	}
+	sort.Sort(nicsByIndex(nics))
+
+	for _, nic := range nics {
+		if nic.Bridge == "" {
+			nic.Bridge = vm.Property("bridge")
+		}
+		if nic.MAC == "" {
+			nic.MAC = vm.NICMAC(nic.Index)
+		}
+	}
+	return nics, nil
+}
+
+type nicsByIndex []*NIC
+
+func (nn nicsByIndex) Len() int           { return len(nn) }
+func (nn nicsByIndex) Less(i, j int) bool { return nn[i].Index < nn[j].Index }
+func (nn nicsByIndex) Swap(i, j int)      { nn[i], nn[j] = nn[j], nn[i] }
+
+// MAC returns MAC address of the VM's net0 network interface, or an
+// empty string if it has none.
+func (vm *VM) MAC() string {
+	if nics, err := vm.NICs(); err == nil && nics[0].Index == 0 {
+		return nics[0].MAC
+	}
+	return ""
+}
+
+// NICMAC returns a stable MAC address for the VM's index-th network
+// interface, derived from the VM's name.
+func (vm *VM) NICMAC(index int) string {
+	seed := vm.Name
+	if index > 0 {
+		seed = fmt.Sprintf("%s/net%d", vm.Name, index)
+	}
+	hsh := md5.Sum([]byte(seed))
+	hw := make(net.HardwareAddr, 6)
+	hw[0] = 0x02
+	hw[1] = 0xAB
+	hw[2] = 0xEE
+	hw[3] = hsh[0]
+	hw[4] = hsh[1]
+	hw[5] = hsh[2]
+	return hw.String()
+}
+
+func (vm *VM) ensureBridge(bridge string) string {
+	if _, err := net.InterfaceByName(bridge); err != nil {
+		if err := run(vm.ex, nil, os.Stdout, "ifconfig", bridge, "create"); err != nil {
+			panic(err)
+		}
+	}
+	return bridge
+}
+
+// Bridge returns the bridge of the VM's first network interface,
+// creating it if it doesn't exist.
+func (vm *VM) Bridge() string {
+	if nics, err := vm.NICs(); err != nil {
+		panic(err)
+	} else {
+		return vm.ensureBridge(nics[0].Bridge)
+	}
+}
+
+var rxSpace = regexp.MustCompile(`\s+`)
+
+// Tap returns tap interface of the VM's first network interface.
+func (vm *VM) Tap(create bool) string {
+	if taps := vm.Taps(create); len(taps) > 0 {
+		return taps[0]
+	}
+	return ""
+}
+
+// Taps returns tap interfaces of the VM's network interfaces, in order.
+// If create is true and the VM has no taps yet, they are created and
+// added to the NICs' bridges, which are recorded along with the taps.
+func (vm *VM) Taps(create bool) []string {
+	if len(vm.taps) == 0 {
+		if st := vm.State(); st != nil {
+			vm.taps, vm.bridges = st.Taps, st.Bridges
+		}
+	}
+	// Fall back to fstat for VMs started by older versions
+	if pid := vm.BhyvePid(); len(vm.taps) == 0 && pid != 0 {
+		if out, err := runStdout(vm.ex, nil, "fstat", "-p", strconv.Itoa(pid), "-f", "/dev"); err != nil {
+			cli.Error(err)
+		} else {
+			for _, ln := range strings.Split(out, "\n") {
+				if ln == "" {
+					continue
+				}
+				lnw := rxSpace.Split(ln, -1)
+				if dev := lnw[len(lnw)-2]; strings.HasPrefix(dev, "tap") {
+					vm.taps = append(vm.taps, dev)
+				}
+			}
+		}
+	}
+	if len(vm.taps) == 0 && create {
+		nics, err := vm.NICs()
+		if err != nil {
+			panic(err)
+		}
+		for _, nic := range nics {
+			if tap, err := runStdout(vm.ex, nil, "ifconfig", "tap", "create"); err != nil {
+				panic(err)
+			} else {
+				tap = strings.TrimSpace(tap)
+				vm.taps = append(vm.taps, tap)
+				bridge := vm.ensureBridge(nic.Bridge)
+				vm.bridges = append(vm.bridges, bridge)
+				if err := run(vm.ex, nil, os.Stdout, "ifconfig", bridge, "addm", tap); err != nil {
+					panic(err)
+				}
+				if nic.VLAN != 0 {
+					if err := run(vm.ex, nil, os.Stdout, "ifconfig", bridge, "untagged", tap, strconv.Itoa(nic.VLAN)); err != nil {
+						panic(err)
+					}
+				}
+			}
+		}
+	}
+	return vm.taps
+}
+
+// destroyTaps removes the VM's taps from the bridges they were added to,
+// which may differ from the NICs' current ones, and destroys them.
+func (vm *VM) destroyTaps() {
+	for i, tap := range vm.taps {
+		if i < len(vm.bridges) {
+			run(vm.ex, nil, os.Stdout, "ifconfig", vm.bridges[i], "deletem", tap)
+		}
+		run(vm.ex, nil, os.Stdout, "ifconfig", tap, "destroy")
+	}
+	vm.taps, vm.bridges = nil, nil
+}
@@ -0,0 +1,73 @@
+package vm
+
+import "os"
+import "testing"
+
+func TestTaps(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["net0"] = "bktest1,vlan=10"
+	vm.Properties["net2"] = ""
+
+	taps := vm.Taps(true)
+	if len(taps) != 2 || taps[0] != "tap0" || taps[1] != "tap1" {
+		t.Errorf("Unexpected taps: %#v", taps)
+	}
+	expectCommands(t, ex, "ifconfig",
+		"ifconfig tap create",
+		"ifconfig bktest1 create",
+		"ifconfig bktest1 addm tap0",
+		"ifconfig bktest1 untagged tap0 10",
+		"ifconfig tap create",
+		"ifconfig bktest0 create",
+		"ifconfig bktest0 addm tap1")
+	if len(vm.bridges) != 2 || vm.bridges[0] != "bktest1" || vm.bridges[1] != "bktest0" {
+		t.Errorf("Unexpected bridges: %#v", vm.bridges)
+	}
+
+	ex.Calls = nil
+	vm.destroyTaps()
+	expectCommands(t, ex, "ifconfig",
+		"ifconfig bktest1 deletem tap0",
+		"ifconfig tap0 destroy",
+		"ifconfig bktest0 deletem tap1",
+		"ifconfig tap1 destroy")
+}
+
+// Taps are taken off the bridges recorded in the state when they were
+// created, even if the VM's bridges are changed while it runs.
+func TestTapsChangedBridge(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["net1"] = "bktest1"
+	vm.state = &State{Runner: os.Getpid()}
+	vm.Taps(true)
+	vm.state.Taps, vm.state.Bridges = vm.taps, vm.bridges
+	vm.saveState()
+
+	running := NewVM(ex, "test", "tank/test")
+	running.Properties["net1"] = "bktest2"
+	running.Properties["bridge"] = "bktest3"
+	ex.Calls = nil
+	running.Taps(false)
+	running.destroyTaps()
+	expectCommands(t, ex, "ifconfig",
+		"ifconfig bktest1 deletem tap0",
+		"ifconfig tap0 destroy")
+}
+
+func TestMAC(t *testing.T) {
+	vm, _ := testVM(t)
+	if mac := vm.MAC(); mac != vm.NICMAC(0) {
+		t.Errorf("MAC of default net0: %#v", mac)
+	}
+
+	vm.Properties["net0"] = ",mac=02:00:00:00:00:01"
+	if mac := vm.MAC(); mac != "02:00:00:00:00:01" {
+		t.Errorf("MAC of net0 with mac: %#v", mac)
+	}
+
+	delete(vm.Properties, "net0")
+	vm.Properties["net1"] = ""
+	if mac := vm.MAC(); mac != "" {
+		t.Errorf("MAC without net0: %#v", mac)
+	}
+}
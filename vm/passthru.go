@@ -0,0 +1,115 @@
+package vm
+
+import "fmt"
+import "strconv"
+import "strings"
+
+// ParsePassthru parses value of the passthru property: host PCI devices
+// as BUS/SLOT/FUNCTION triples, separated by spaces or commas.
+func ParsePassthru(value string) ([]string, error) {
+	var devs []string
+	for _, dev := range splitList(value) {
+		if _, err := parseBSF(dev); err != nil {
+			return nil, err
+		}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// parseBSF parses a BUS/SLOT/FUNCTION triple.
+func parseBSF(value string) ([3]int, error) {
+	var bsf [3]int
+	parts := strings.Split(value, "/")
+	if len(parts) != 3 {
+		return bsf, fmt.Errorf("Invalid PCI device %#v, expected BUS/SLOT/FUNCTION", value)
+	}
+	for i, max := range []int{255, 31, 7} {
+		if n, err := strconv.Atoi(parts[i]); err != nil || n < 0 || n > max {
+			return bsf, fmt.Errorf("Invalid PCI device %#v, expected BUS/SLOT/FUNCTION", value)
+		} else {
+			bsf[i] = n
+		}
+	}
+	return bsf, nil
+}
+
+func checkPassthru(ex Executor, value string) error {
+	_, err := ParsePassthru(value)
+	return err
+}
+
+// Passthru returns host PCI devices passed through to the VM.
+func (vm *VM) Passthru() []string {
+	devs, _ := ParsePassthru(vm.Property("passthru"))
+	return devs
+}
+
+// pptDevices returns host PCI devices reserved for passthrough
+// (attached to the ppt driver), according to pciconf.
+func pptDevices(ex Executor) (map[[3]int]bool, error) {
+	out, err := runStdout(ex, nil, "pciconf", "-l")
+	if err != nil {
+		return nil, err
+	}
+	// ppt0@pci0:6:0:0:	class=0x020000 ...
+	ppt := make(map[[3]int]bool)
+	for _, ln := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(ln, "ppt") {
+			continue
+		}
+		at := strings.Index(ln, "@pci")
+		if at < 0 {
+			continue
+		}
+		sel := strings.Split(ln[at+4:], ":")
+		if len(sel) < 4 || sel[0] != "0" {
+			continue
+		}
+		if bsf, err := parseBSF(strings.Join(sel[1:4], "/")); err == nil {
+			ppt[bsf] = true
+		}
+	}
+	return ppt, nil
+}
+
+// checkPassthruAvailable checks that the VM's passthrough devices are
+// reserved for ppt on the host, and not used by any other running VM.
+func (vm *VM) checkPassthruAvailable() error {
+	devs := vm.Passthru()
+	if len(devs) == 0 {
+		return nil
+	}
+
+	ppt, err := pptDevices(vm.ex)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[[3]int]string)
+	for _, dev := range devs {
+		bsf, _ := parseBSF(dev)
+		if !ppt[bsf] {
+			return fmt.Errorf("PCI device %s is not reserved for passthrough (add it to pptdevs in /boot/loader.conf)", dev)
+		}
+		wanted[bsf] = dev
+	}
+
+	vms, err := AllVMs(vm.ex)
+	if err != nil {
+		return err
+	}
+	for _, other := range vms {
+		if other.Name == vm.Name || !other.Exists() {
+			continue
+		}
+		if err := other.LoadProperties(); err != nil {
+			return err
+		}
+		for _, otherDev := range other.Passthru() {
+			if bsf, _ := parseBSF(otherDev); wanted[bsf] != "" {
+				return fmt.Errorf("PCI device %s is used by running VM %s", wanted[bsf], other.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package vm
+
+import "strings"
+import "testing"
+
+const testPciconf = "ppt0@pci0:6:0:0:\tclass=0x020000 card=0x00008086 chip=0x10fb8086 rev=0x01 hdr=0x00\n" +
+	"em0@pci0:0:25:0:\tclass=0x020000 card=0x00008086 chip=0x15028086 rev=0x04 hdr=0x00\n"
+
+// Passthrough wires the VM's memory, also for the loader.
+func TestLoadPassthru(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["loader"] = "bhyveload"
+	vm.Properties["passthru"] = "6/0/0"
+	ex.On("pciconf", "-l").Output(testPciconf)
+
+	if err := vm.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectCommands(t, ex, "bhyveload", "bhyveload -m 1024 -d /dev/zvol/tank/test -S test")
+	expectArgs(t, vm, "-c 1 -m 1024 -A -P -H -l com1,stdio -S"+
+		" -s 0:0,hostbridge -s 1:0,lpc -s 2:0,virtio-blk,/dev/zvol/tank/test"+
+		" -s 3:0,virtio-net,tap0,mac="+vm.MAC()+" -s 4:0,passthru,6/0/0 test")
+}
+
+func TestPassthruNotReserved(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["passthru"] = "6/0/0 0/25/0"
+	ex.On("pciconf", "-l").Output(testPciconf)
+
+	if err := vm.checkPassthruAvailable(); err == nil || !strings.Contains(err.Error(), "0/25/0 is not reserved") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package vm
+
+import "os"
+import "path/filepath"
+
+// Directory for runtime files of VMs: supervisor pidfiles, logs, and
+// such. Each VM gets its own subdirectory.
+var RunDir = "/var/run/bheekeeper"
+
+// Directory for persistent files of VMs, such as UEFI variables. Each
+// VM gets its own subdirectory.
+var DataDir = "/var/db/bheekeeper"
+
+func (vm *VM) runPath(name string) string {
+	return filepath.Join(RunDir, vm.Name, name)
+}
+
+func (vm *VM) dataPath(name string) string {
+	return filepath.Join(DataDir, vm.Name, name)
+}
+
+// ensureDataDir creates the VM's data directory, if it doesn't exist.
+func (vm *VM) ensureDataDir() error {
+	return os.MkdirAll(vm.dataPath(""), 0755)
+}
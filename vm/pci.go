@@ -0,0 +1,292 @@
+package vm
+
+import "fmt"
+import "sort"
+import "strconv"
+import "strings"
+
+const pciPinPrefix = "pci:"
+
+// Number of slots on bhyve's PCI bus, and functions in a slot
+const (
+	pciSlots     = 32
+	pciFunctions = 8
+)
+
+// PCIAddress is a slot and function on the VM's PCI bus.
+type PCIAddress struct {
+	Slot     int `json:"slot"`
+	Function int `json:"function"`
+}
+
+func (a PCIAddress) String() string {
+	return fmt.Sprintf("%d:%d", a.Slot, a.Function)
+}
+
+// ParsePCIAddress parses a PCI address: SLOT[:FUNCTION].
+func ParsePCIAddress(value string) (PCIAddress, error) {
+	var addr PCIAddress
+	parts := strings.SplitN(value, ":", 2)
+	if slot, err := strconv.Atoi(parts[0]); err != nil || slot < 0 || slot >= pciSlots {
+		return addr, fmt.Errorf("Invalid PCI slot %#v, expected 0..%d", parts[0], pciSlots-1)
+	} else {
+		addr.Slot = slot
+	}
+	if len(parts) > 1 {
+		if fn, err := strconv.Atoi(parts[1]); err != nil || fn < 0 || fn >= pciFunctions {
+			return addr, fmt.Errorf("Invalid PCI function %#v, expected 0..%d", parts[1], pciFunctions-1)
+		} else {
+			addr.Function = fn
+		}
+	}
+	return addr, nil
+}
+
+func checkPCIAddress(ex Executor, value string) error {
+	_, err := ParsePCIAddress(value)
+	return err
+}
+
+// PCIDevice is a device on the VM's PCI bus. Device is bhyve's device
+// specification; it is filled in only when the VM is loaded.
+type PCIDevice struct {
+	Name    string     `json:"name"`
+	Address PCIAddress `json:"address"`
+	Device  string     `json:"device,omitempty"`
+	Pinned  bool       `json:"pinned,omitempty"`
+	fixed   bool       // can't be moved
+	prefer  *PCIAddress
+}
+
+// Arg returns bhyve's -s switch value for the device.
+func (d *PCIDevice) Arg() string {
+	return d.Address.String() + "," + d.Device
+}
+
+// pciDevices lists the VM's PCI devices, in allocation order, without
+// addresses.
+func (vm *VM) pciDevices() ([]*PCIDevice, error) {
+	disks, err := vm.Disks()
+	if err != nil {
+		return nil, err
+	}
+	nics, err := vm.NICs()
+	if err != nil {
+		return nil, err
+	}
+	shares, err := vm.Shares()
+	if err != nil {
+		return nil, err
+	}
+	channels, err := vm.Channels()
+	if err != nil {
+		return nil, err
+	}
+
+	devs := []*PCIDevice{
+		{Name: "hostbridge", fixed: true, prefer: &PCIAddress{0, 0}},
+		{Name: "lpc", fixed: true, prefer: &PCIAddress{1, 0}},
+		{Name: fmt.Sprintf("disk%d", disks[0].Index), prefer: &PCIAddress{2, 0}},
+	}
+	if vm.Property("cdrom_iso") != "" {
+		devs = append(devs, &PCIDevice{Name: "cdrom", prefer: &PCIAddress{2, 1}})
+	}
+	for _, nic := range nics {
+		devs = append(devs, &PCIDevice{Name: fmt.Sprintf("net%d", nic.Index)})
+	}
+	for _, disk := range disks[1:] {
+		devs = append(devs, &PCIDevice{Name: fmt.Sprintf("disk%d", disk.Index)})
+	}
+	if vm.HasVNC() {
+		devs = append(devs, &PCIDevice{Name: "fbuf"}, &PCIDevice{Name: "tablet"})
+	}
+	for i := range vm.Passthru() {
+		devs = append(devs, &PCIDevice{Name: fmt.Sprintf("passthru%d", i)})
+	}
+	for _, share := range shares {
+		devs = append(devs, &PCIDevice{Name: sharePrefix + share.Tag})
+	}
+	if vm.HasEntropy() {
+		devs = append(devs, &PCIDevice{Name: "rnd"})
+	}
+	if len(channels) > 0 {
+		devs = append(devs, &PCIDevice{Name: "console"})
+	}
+	return devs, nil
+}
+
+// PCIDevices returns the VM's PCI devices with their addresses, ordered
+// by address. Devices can be pinned to an address with pci:NAME
+// properties; the remaining ones are placed in the first free slots, in
+// a fixed order, so that their addresses don't change between boots
+// unless the VM's devices change.
+func (vm *VM) PCIDevices() ([]*PCIDevice, error) {
+	devs, err := vm.pciDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*PCIDevice, len(devs))
+	for _, dev := range devs {
+		byName[dev.Name] = dev
+	}
+	for name, value := range vm.Properties {
+		if !strings.HasPrefix(name, pciPinPrefix) {
+			continue
+		}
+		dev := byName[name[len(pciPinPrefix):]]
+		switch {
+		case dev == nil:
+			return nil, fmt.Errorf("%s: no such PCI device", name)
+		case dev.fixed:
+			return nil, fmt.Errorf("%s: %s can't be moved", name, dev.Name)
+		}
+		if addr, err := ParsePCIAddress(value); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		} else {
+			dev.Address = addr
+			dev.Pinned = true
+		}
+	}
+
+	if err := allocatePCI(devs); err != nil {
+		return nil, err
+	}
+	sort.Sort(pciDevicesByAddress(devs))
+	return devs, nil
+}
+
+// allocatePCI assigns addresses to devices: fixed and pinned ones
+// first, then ones that prefer a free address, then the rest, each to
+// function 0 of the first free slot.
+func allocatePCI(devs []*PCIDevice) error {
+	used := make(map[PCIAddress]*PCIDevice)
+	slotUsed := make(map[int]bool)
+	take := func(dev *PCIDevice, addr PCIAddress) {
+		dev.Address = addr
+		used[addr] = dev
+		slotUsed[addr.Slot] = true
+	}
+
+	for _, dev := range devs {
+		if dev.fixed {
+			take(dev, *dev.prefer)
+		}
+	}
+	for _, dev := range devs {
+		if dev.Pinned {
+			if other := used[dev.Address]; other != nil {
+				return fmt.Errorf("%s%s: PCI address %s is taken by %s", pciPinPrefix, dev.Name, dev.Address, other.Name)
+			}
+			take(dev, dev.Address)
+		}
+	}
+
+	var rest []*PCIDevice
+	for _, dev := range devs {
+		if dev.fixed || dev.Pinned {
+			continue
+		}
+		// A preferred non-zero function is only used if function 0 of
+		// the slot is there.
+		if pref := dev.prefer; pref != nil && used[*pref] == nil &&
+			(pref.Function == 0 || used[PCIAddress{pref.Slot, 0}] != nil) {
+			take(dev, *pref)
+		} else {
+			rest = append(rest, dev)
+		}
+	}
+
+	slot := 0
+	for _, dev := range rest {
+		for slot < pciSlots && slotUsed[slot] {
+			slot++
+		}
+		if slot == pciSlots {
+			return fmt.Errorf("No free PCI slot for %s", dev.Name)
+		}
+		take(dev, PCIAddress{slot, 0})
+	}
+
+	for addr, dev := range used {
+		if used[PCIAddress{addr.Slot, 0}] == nil {
+			return fmt.Errorf("%s is at PCI address %s, but slot %d has no function 0", dev.Name, addr, addr.Slot)
+		}
+	}
+	return nil
+}
+
+// loadPCIDevices returns the VM's PCI devices with their bhyve device
+// specifications, creating the VM's taps.
+func (vm *VM) loadPCIDevices() ([]*PCIDevice, error) {
+	devs, err := vm.PCIDevices()
+	if err != nil {
+		return nil, err
+	}
+	disks, err := vm.Disks()
+	if err != nil {
+		return nil, err
+	}
+	nics, err := vm.NICs()
+	if err != nil {
+		return nil, err
+	}
+	shares, err := vm.Shares()
+	if err != nil {
+		return nil, err
+	}
+	channels, err := vm.Channels()
+	if err != nil {
+		return nil, err
+	}
+
+	specs := map[string]string{
+		"hostbridge": "hostbridge",
+		"lpc":        "lpc",
+		"cdrom":      "ahci-cd," + vm.Property("cdrom_iso"),
+	}
+	for _, disk := range disks {
+		specs[fmt.Sprintf("disk%d", disk.Index)] = disk.Device()
+	}
+	for i, tap := range vm.Taps(true) {
+		specs[fmt.Sprintf("net%d", nics[i].Index)] = nics[i].Device(tap)
+	}
+	if vm.HasVNC() {
+		if vnc, err := vm.vncDevices(); err != nil {
+			return nil, err
+		} else {
+			specs["fbuf"], specs["tablet"] = vnc[0], vnc[1]
+		}
+	}
+
+	for i, dev := range vm.Passthru() {
+		specs[fmt.Sprintf("passthru%d", i)] = "passthru," + dev
+	}
+	for _, share := range shares {
+		specs[sharePrefix+share.Tag] = share.Device()
+	}
+	specs["rnd"] = "virtio-rnd"
+	if len(channels) > 0 {
+		if dev, err := vm.consoleDevice(channels); err != nil {
+			return nil, err
+		} else {
+			specs["console"] = dev
+		}
+	}
+
+	for _, dev := range devs {
+		dev.Device = specs[dev.Name]
+	}
+	return devs, nil
+}
+
+type pciDevicesByAddress []*PCIDevice
+
+func (dd pciDevicesByAddress) Len() int { return len(dd) }
+func (dd pciDevicesByAddress) Less(i, j int) bool {
+	if dd[i].Address.Slot != dd[j].Address.Slot {
+		return dd[i].Address.Slot < dd[j].Address.Slot
+	}
+	return dd[i].Address.Function < dd[j].Address.Function
+}
+func (dd pciDevicesByAddress) Swap(i, j int) { dd[i], dd[j] = dd[j], dd[i] }
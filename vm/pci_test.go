@@ -0,0 +1,106 @@
+package vm
+
+import "io/ioutil"
+import "path/filepath"
+import "reflect"
+import "strconv"
+import "strings"
+import "testing"
+
+// pciLayout returns the VM's PCI devices as ADDRESS NAME strings.
+func pciLayout(t *testing.T, vm *VM) []string {
+	t.Helper()
+	devs, err := vm.PCIDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layout []string
+	for _, dev := range devs {
+		layout = append(layout, dev.Address.String()+" "+dev.Name)
+	}
+	return layout
+}
+
+func TestPCIDevices(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["cdrom_iso"] = "/dev/null"
+	vm.Properties["disk1"] = "/dev/null"
+	vm.Properties["entropy"] = "yes"
+	vm.Properties["pci:rnd"] = "3"
+	vm.Properties["pci:disk1"] = "10:0"
+
+	if layout, expected := pciLayout(t, vm), []string{
+		"0:0 hostbridge", "1:0 lpc", "2:0 disk0", "2:1 cdrom", "3:0 rnd", "4:0 net0", "10:0 disk1",
+	}; !reflect.DeepEqual(layout, expected) {
+		t.Errorf("PCI layout:\n  got:      %#v\n  expected: %#v", layout, expected)
+	}
+}
+
+// CD-ROM prefers function 1 of the boot disk's slot, but can't be there
+// without the boot disk.
+func TestPCIPreferredFunction(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["cdrom_iso"] = "/dev/null"
+	vm.Properties["pci:disk0"] = "5"
+
+	if layout, expected := pciLayout(t, vm), []string{
+		"0:0 hostbridge", "1:0 lpc", "2:0 cdrom", "3:0 net0", "5:0 disk0",
+	}; !reflect.DeepEqual(layout, expected) {
+		t.Errorf("PCI layout:\n  got:      %#v\n  expected: %#v", layout, expected)
+	}
+}
+
+func TestPCIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		props map[string]string
+		err   string
+	}{
+		{map[string]string{"pci:net0": "1"}, "pci:net0: PCI address 1:0 is taken by lpc"},
+		{map[string]string{"pci:net0": "2", "pci:disk0": "2"}, "is taken by"},
+		{map[string]string{"pci:lpc": "5"}, "pci:lpc: lpc can't be moved"},
+		{map[string]string{"pci:tablet": "5"}, "pci:tablet: no such PCI device"},
+		{map[string]string{"pci:net0": "7:1"}, "net0 is at PCI address 7:1, but slot 7 has no function 0"},
+	} {
+		vm, _ := testVM(t)
+		for name, value := range tc.props {
+			vm.Properties[name] = value
+		}
+		if _, err := vm.PCIDevices(); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: expected error %#v, got %v", tc.props, tc.err, err)
+		}
+	}
+}
+
+func TestPCINoFreeSlot(t *testing.T) {
+	vm, _ := testVM(t)
+	for i := 0; i < pciSlots; i++ {
+		vm.Properties["net"+strconv.Itoa(i)] = ""
+	}
+	if _, err := vm.PCIDevices(); err == nil || !strings.Contains(err.Error(), "No free PCI slot") {
+		t.Errorf("Expected no free PCI slot, got %v", err)
+	}
+}
+
+// Taps created for a VM that fails to load are destroyed.
+func TestLoadPCIDevicesError(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["loader"] = "uefi"
+	vm.Properties["vnc"] = "yes"
+	if err := ioutil.WriteFile(filepath.Join(UEFIFirmwareDir, "BHYVE_UEFI.fd"), []byte("firmware"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ex.On("zfs", "get", "-H", "-t").Exit(1) // VNC port allocation lists other VMs
+
+	if err := vm.Load(); err == nil {
+		t.Fatal("Loaded VM without a VNC port")
+	}
+	expectCommands(t, ex, "ifconfig",
+		"ifconfig tap create",
+		"ifconfig bktest0 create",
+		"ifconfig bktest0 addm tap0",
+		"ifconfig bktest0 deletem tap0",
+		"ifconfig tap0 destroy")
+	if vm.loaded || len(vm.taps) > 0 {
+		t.Error("VM is left loaded")
+	}
+}
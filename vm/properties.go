@@ -0,0 +1,306 @@
+package vm
+
+import "errors"
+import "fmt"
+import "os"
+import "sort"
+import "strings"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+var PropertyDefaults = map[string]string{
+	"bridge":            "bridge0",
+	"console:log":       "no",
+	"console:log_size":  "1M",
+	"console:log_files": "5",
+	"cpus":              "1",
+	"cpu:ignore_msrs":   "no",
+	"cpu:x2apic":        "no",
+	"mem:wired":         "no",
+	"rtc:utc":           "no",
+	"entropy":           "no",
+	"grub:root":         "hd0,msdos1",
+	"loader":            "grub",
+	"mem":               "1024",
+	"restart":           "on-reboot",
+	"restart:max":       "5",
+	"restart:window":    "600",
+	"uefi:vars":         "no",
+	"vnc":               "no",
+	"vnc:listen":        "127.0.0.1",
+	"vnc:resolution":    "1024x768",
+	"vnc:wait":          "no",
+}
+
+var PropertySchema = []*PropertySpec{
+	{Name: "bridge", Type: StringProperty,
+		Help: "Default bridge for the VM's network interfaces"},
+	{Name: "cdrom_iso", Type: PathProperty, Host: true,
+		Help: "ISO image attached as a CD-ROM drive"},
+	{Name: "disk", Type: StringProperty, Indexed: true, Check: checkDisk, HostCheck: checkDiskHost, Clone: cloneDisk,
+		Help: "Disk: PATH[,EMULATION][,OPTION...], where PATH is an image file,\n" +
+			"    device, or ZFS volume name; EMULATION is virtio-blk (default), ahci-hd\n" +
+			"    or nvme; OPTIONs are nocache, direct, ro, and sectorsize=N[/M].\n" +
+			"    disk0 is the boot disk, and its PATH defaults to the VM's volume or\n" +
+			"    image file. Clones get only disk0's EMULATION and OPTIONs"},
+	{Name: "console:log", Type: BoolProperty,
+		Help: "Log console (com1) output, with timestamps, to console.log in the\n" +
+			"    VM's data directory"},
+	{Name: "console:log_size", Type: SizeProperty, Min: 1, Unit: "M",
+		Help: "Size at which console log is rotated"},
+	{Name: "console:log_files", Type: IntProperty, Min: 1,
+		Help: "Number of rotated console logs to keep"},
+	{Name: "com2", Type: StringProperty, Check: checkSerial, Clone: cloneSerial,
+		Help: "Serial port com2: nmdm (device pair for the user), file:PATH (output\n" +
+			"    appended to a file), unix or unix:PATH (unix socket, in the VM's run\n" +
+			"    directory by default)"},
+	{Name: "com3", Type: StringProperty, Check: checkSerial, Clone: cloneSerial,
+		Help: "Serial port com3, like com2"},
+	{Name: "com4", Type: StringProperty, Check: checkSerial, Clone: cloneSerial,
+		Help: "Serial port com4, like com2"},
+	{Name: "cpus", Type: IntProperty, Min: 1, HostCheck: checkHostCPUs,
+		Help: "Number of virtual CPUs"},
+	{Name: "cpu:sockets", Type: IntProperty, Min: 1,
+		Help: "Number of CPU sockets; sockets * cores * threads must equal cpus"},
+	{Name: "cpu:cores", Type: IntProperty, Min: 1,
+		Help: "Number of cores per CPU socket"},
+	{Name: "cpu:threads", Type: IntProperty, Min: 1,
+		Help: "Number of threads per CPU core"},
+	{Name: "cpu:pin", Type: StringProperty, Check: checkPinning, HostCheck: checkPinningHost,
+		Help: "Pin virtual CPUs to host CPUs: VCPU:HOSTCPU pairs, separated by spaces\n" +
+			"    or commas"},
+	{Name: "cpu:ignore_msrs", Type: BoolProperty,
+		Help: "Ignore guest's accesses to unimplemented MSRs"},
+	{Name: "cpu:x2apic", Type: BoolProperty,
+		Help: "Use x2APIC mode"},
+	{Name: "rtc:utc", Type: BoolProperty,
+		Help: "Keep the VM's RTC in UTC rather than local time"},
+	{Name: "net", Type: StringProperty, Indexed: true, Check: checkNIC, Clone: cloneNIC,
+		Help: "Network interface: [BRIDGE][,MODEL][,mac=MAC][,vlan=VLAN], where MODEL\n" +
+			"    is virtio-net (default) or e1000; MAC defaults to one derived from VM's\n" +
+			"    name, and VLAN requires bridge with vlanfilter"},
+	{Name: "grub:in", Type: StringProperty,
+		Help: "Input for grub-bhyve: \"-\" for stdin, or a Go-quoted string"},
+	{Name: "grub:root", Type: StringProperty,
+		Help: "GRUB root device"},
+	{Name: "passthru", Type: StringProperty, Unique: true, Check: checkPassthru,
+		Help: "Host PCI devices to pass through to the VM, as BUS/SLOT/FUNCTION,\n" +
+			"    separated by spaces or commas; they must be reserved with pptdevs"},
+	{Name: "pci:", Type: StringProperty, Prefix: true, Check: checkPCIAddress,
+		Help: "PCI address of device KEY (disk<N>, net<N>, cdrom, fbuf, tablet,\n" +
+			"    passthru<N>, share:<TAG>, rnd, console): SLOT[:FUNCTION]; unpinned\n" +
+			"    devices take first free slots"},
+	{Name: "entropy", Type: BoolProperty,
+		Help: "Attach a virtio-rnd device, feeding the guest with host's entropy"},
+	{Name: "channel:", Type: BoolProperty, Prefix: true, CheckKey: checkTag,
+		Help: "Attach virtio-console port KEY, backed by a unix socket in the VM's\n" +
+			"    run directory"},
+	{Name: "share:", Type: StringProperty, Prefix: true, Host: true, CheckKey: checkTag, Check: checkShare, HostCheck: checkShareHost,
+		Help: "Host directory shared with the VM over virtio-9p with tag KEY:\n" +
+			"    PATH[,ro]"},
+	{Name: "loader", Type: EnumProperty, Values: []string{"grub", "bhyveload", "uefi", "uefi-csm"},
+		Help: "How to boot the VM: with grub-bhyve, bhyveload (FreeBSD guests), or\n" +
+			"    UEFI firmware (uefi-csm supports legacy BIOS guests)"},
+	{Name: "bhyveload:env:", Type: StringProperty, Prefix: true,
+		Help: "Loader environment variable KEY for bhyveload, as in loader.conf"},
+	{Name: "bhyveload:in", Type: StringProperty,
+		Help: "Input for bhyveload: \"-\" for stdin, or a Go-quoted string"},
+	{Name: "restart", Type: EnumProperty, Values: []string{"never", "on-reboot", "on-failure", "always"},
+		Help: "When to start the VM again after it exits: never, on-reboot, on-failure\n" +
+			"    (reboot, error or triple fault), or always (unless stopped)"},
+	{Name: "restart:max", Type: IntProperty, Min: 1,
+		Help: "Maximum number of restarts (other than reboots) within restart:window"},
+	{Name: "restart:window", Type: IntProperty, Min: 1, Unit: "s",
+		Help: "Time window for restart:max, in seconds; delay between restarts\n" +
+			"    doubles with each restart within it"},
+	{Name: "uefi:firmware", Type: PathProperty, Host: true,
+		Help: "UEFI firmware (default: BHYVE_UEFI.fd or BHYVE_UEFI_CSM.fd from " + UEFIFirmwareDir + ")"},
+	{Name: "uefi:vars", Type: BoolProperty,
+		Help: "Keep UEFI variables (NVRAM) in a file, carried along with snapshots, clones and exports"},
+	{Name: "vnc", Type: BoolProperty,
+		Help: "Attach a VNC framebuffer and a USB tablet (requires UEFI loader)"},
+	{Name: "vnc:listen", Type: StringProperty, Check: checkIP,
+		Help: "IP address for the VNC server to listen on"},
+	{Name: "vnc:port", Type: IntProperty, Min: 1, Max: 65535, Unique: true,
+		Help: "VNC server's port (default: first free one from 5900)"},
+	{Name: "vnc:resolution", Type: StringProperty, Check: checkResolution,
+		Help: "Framebuffer resolution: WIDTHxHEIGHT, up to 1920x1200"},
+	{Name: "vnc:password", Type: StringProperty, Secret: true, Check: checkVNCPassword,
+		Help: "Password for the VNC server"},
+	{Name: "vnc:wait", Type: BoolProperty,
+		Help: "Wait for a VNC connection before booting the VM"},
+	{Name: "mem", Type: SizeProperty, Min: 32, Unit: "M",
+		Help: "Memory size, with optional K, M, G or T suffix"},
+	{Name: "mem:wired", Type: BoolProperty,
+		Help: "Wire the VM's memory, so that it's never swapped out (always on with\n" +
+			"    passthru)"},
+}
+
+var ErrReadOnlyProperty = errors.New("Property is read-only")
+
+func IsKnownProperty(name string) bool {
+	return LookupProperty(name) != nil
+}
+
+func checkProperty(name string) error {
+	if name == "name" {
+		return ErrReadOnlyProperty
+	}
+	if !IsKnownProperty(name) {
+		return fmt.Errorf("Unknown property: %s", name)
+	}
+	return nil
+}
+
+// ValidateProperty checks that value is valid for the named property.
+func ValidateProperty(ex Executor, name, value string) error {
+	if err := checkProperty(name); err != nil {
+		return err
+	}
+	spec := LookupProperty(name)
+	if err := spec.checkKey(name); err != nil {
+		return err
+	}
+	return spec.Validate(ex, value)
+}
+
+// Validate checks all properties set on the VM, and returns an error
+// listing every invalid one.
+func (vm *VM) Validate() error {
+	var errs []string
+	for _, name := range vm.PropertyNames() {
+		value, isSet := vm.Properties[name]
+		if !isSet || name == "name" {
+			continue
+		}
+		if !IsKnownProperty(name) {
+			cli.Infof("Ignoring unknown property: %s", name)
+			continue
+		}
+		if err := ValidateProperty(vm.ex, name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	errs = append(errs, vm.checkCPUs()...)
+	if err := vm.checkVNC(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, err := vm.PCIDevices(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Invalid properties of %s:\n  %s", vm.Name, strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func (vm *VM) LoadProperties() error {
+	props, err := zfs_peek(vm.ex, "get", "-o", "property,value,source", "all", vm.Volume)
+	if err != nil {
+		return err
+	}
+	vm.Properties = make(map[string]string)
+	vm.sources = make(map[string]string)
+	for _, prop := range props {
+		switch prop[0] {
+		case "type":
+			vm.filesystem = prop[1] == "filesystem"
+		case "mountpoint":
+			vm.mountpoint = prop[1]
+		}
+		if !strings.HasPrefix(prop[0], "bhyve:") {
+			continue
+		}
+		vm.Properties[prop[0][6:]] = prop[1]
+		if len(prop) > 2 {
+			vm.sources[prop[0][6:]] = prop[2]
+		}
+	}
+	return nil
+}
+
+func (vm *VM) Property(name string) string {
+	if val, exists := vm.Properties[name]; exists {
+		return val
+	} else {
+		return PropertyDefaults[name]
+	}
+}
+
+// HasProperty is true if property is known, or is set on the VM.
+func (vm *VM) HasProperty(name string) bool {
+	_, isSet := vm.Properties[name]
+	return isSet || IsKnownProperty(name)
+}
+
+// PropertySource tells where property's value comes from: "local",
+// "inherited from DATASET", "default", or empty string if property is
+// not set at all.
+func (vm *VM) PropertySource(name string) string {
+	if _, exists := vm.Properties[name]; exists {
+		if src := vm.sources[name]; src != "" {
+			return src
+		}
+		return "local"
+	}
+	if _, exists := PropertyDefaults[name]; exists {
+		return "default"
+	}
+	return ""
+}
+
+// PropertyNames returns sorted names of all known properties, and of
+// any other property set on the VM.
+func (vm *VM) PropertyNames() []string {
+	var names []string
+	for _, spec := range PropertySchema {
+		if _, isSet := vm.Properties[spec.Name]; !spec.Indexed && !spec.Prefix && !isSet {
+			names = append(names, spec.Name)
+		}
+	}
+	for name := range vm.Properties {
+		if name != "name" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetProperties validates all the properties, and then sets them at
+// once, so that either all or none of them are set.
+func (vm *VM) SetProperties(props map[string]string) error {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{"set"}
+	for _, name := range names {
+		if err := ValidateProperty(vm.ex, name, props[name]); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		args = append(args, "bhyve:"+name+"="+props[name])
+	}
+	if err := run(vm.ex, nil, os.Stdout, "zfs", append(args, vm.Volume)...); err != nil {
+		return err
+	}
+	for _, name := range names {
+		vm.Properties[name] = props[name]
+		vm.sources[name] = "local"
+	}
+	return nil
+}
+
+// UnsetProperty removes local value of the property, so that it is
+// inherited from parent dataset or falls back to default value.
+func (vm *VM) UnsetProperty(name string) error {
+	if err := checkProperty(name); err != nil {
+		return err
+	}
+	if err := run(vm.ex, nil, os.Stdout, "zfs", "inherit", "bhyve:"+name, vm.Volume); err != nil {
+		return err
+	}
+	return vm.LoadProperties()
+}
@@ -0,0 +1,26 @@
+package vm
+
+import "testing"
+
+func TestSetProperties(t *testing.T) {
+	vm, ex := testVM(t)
+	if err := vm.SetProperties(map[string]string{"mem": "2G", "cpus": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "zfs", "zfs set bhyve:cpus=2 bhyve:mem=2G tank/test")
+	if vm.Property("mem") != "2G" || vm.PropertySource("cpus") != "local" {
+		t.Errorf("Properties not updated: %#v", vm.Properties)
+	}
+}
+
+// None of the properties is set if any of them is invalid.
+func TestSetPropertiesInvalid(t *testing.T) {
+	vm, ex := testVM(t)
+	if err := vm.SetProperties(map[string]string{"cpus": "2", "mem": "lots"}); err == nil {
+		t.Error("Set invalid property")
+	}
+	expectCommands(t, ex, "zfs")
+	if _, isSet := vm.Properties["cpus"]; isSet {
+		t.Error("Valid property set along with invalid one")
+	}
+}
@@ -0,0 +1,114 @@
+package vm
+
+import "os"
+import "strconv"
+import "time"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+// Delay before the first restart after a failure; it doubles with each
+// restart within the restart window, up to maxRestartDelay.
+var restartDelay = time.Second
+
+const maxRestartDelay = 5 * time.Minute
+
+// failed returns true if the status means the VM crashed.
+func (s VMStatus) failed() bool {
+	return s == VMError || s == VMTripleFault
+}
+
+// shouldRestart tells whether the VM should be restarted after it exited
+// with status, according to its restart property.
+func (vm *VM) shouldRestart(status VMStatus) bool {
+	switch vm.Property("restart") {
+	case "never":
+		return false
+	case "on-failure":
+		return status == VMRebooted || status.failed()
+	case "always":
+		return true
+	default: // on-reboot
+		return status == VMRebooted
+	}
+}
+
+func (vm *VM) stopRequestPath() string {
+	return vm.runPath("stop-requested")
+}
+
+// runnerPid returns pid of the process whose Run loop holds the VM
+// (supervisor or foreground run), or 0. The loop may be waiting to
+// restart the VM while bhyve is not running.
+func (vm *VM) runnerPid() int {
+	if st := vm.State(); st != nil && st.Runner != 0 {
+		return st.Runner
+	}
+	return vm.SupervisorPid()
+}
+
+// requestStop tells the process running the VM not to restart it.
+func (vm *VM) requestStop() {
+	if err := os.MkdirAll(vm.runPath(""), 0755); err != nil {
+		cli.Error(err)
+	} else if f, err := os.Create(vm.stopRequestPath()); err != nil {
+		cli.Error(err)
+	} else {
+		f.Close()
+	}
+}
+
+// stopRequested returns true, and clears the request, if the VM has
+// been requested to stop.
+func (vm *VM) stopRequested() bool {
+	return os.Remove(vm.stopRequestPath()) == nil
+}
+
+// restarter keeps track of the VM's restarts, other than reboots, to
+// compute backoff delay and to give up after too many.
+type restarter struct {
+	vm       *VM
+	max      int
+	window   time.Duration
+	restarts []time.Time
+}
+
+func (vm *VM) newRestarter() *restarter {
+	max, _ := strconv.Atoi(vm.Property("restart:max"))
+	window, _ := strconv.Atoi(vm.Property("restart:window"))
+	return &restarter{vm: vm, max: max, window: time.Duration(window) * time.Second}
+}
+
+// restart waits before restarting the VM after it exited with status,
+// and returns false if the VM shouldn't be restarted after all.
+func (r *restarter) restart(status VMStatus) bool {
+	if status == VMRebooted {
+		return true
+	}
+
+	now := time.Now()
+	recent := r.restarts[:0]
+	for _, t := range r.restarts {
+		if now.Sub(t) < r.window {
+			recent = append(recent, t)
+		}
+	}
+	r.restarts = recent
+	if len(r.restarts) >= r.max {
+		cli.Infof("%s: %s, restarted %d times within %v, giving up", r.vm.Name, status, len(r.restarts), r.window)
+		return false
+	}
+
+	delay := restartDelay << uint(len(r.restarts))
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+	r.restarts = append(r.restarts, now)
+	cli.Infof("%s: %s, restarting in %v (%d/%d)", r.vm.Name, status, delay, len(r.restarts), r.max)
+
+	for deadline := now.Add(delay); time.Now().Before(deadline); time.Sleep(stopPollInterval) {
+		if r.vm.stopRequested() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package vm
+
+import "testing"
+import "time"
+
+func TestShouldRestart(t *testing.T) {
+	statuses := []VMStatus{VMRebooted, VMPoweroff, VMHalted, VMTripleFault, VMError}
+	for policy, expected := range map[string][]bool{
+		"":           {true, false, false, false, false}, // on-reboot
+		"never":      {false, false, false, false, false},
+		"on-reboot":  {true, false, false, false, false},
+		"on-failure": {true, false, false, true, true},
+		"always":     {true, true, true, true, true},
+	} {
+		vm, _ := testVM(t)
+		if policy != "" {
+			vm.Properties["restart"] = policy
+		}
+		for i, status := range statuses {
+			if restart := vm.shouldRestart(status); restart != expected[i] {
+				t.Errorf("restart=%s, %s: expected %v, got %v", policy, status, expected[i], restart)
+			}
+		}
+	}
+}
+
+// fastRestarts makes restart delays short for the test.
+func fastRestarts(t *testing.T) {
+	origDelay, origPoll := restartDelay, stopPollInterval
+	restartDelay, stopPollInterval = 10*time.Millisecond, time.Millisecond
+	t.Cleanup(func() { restartDelay, stopPollInterval = origDelay, origPoll })
+}
+
+func TestRestarter(t *testing.T) {
+	fastRestarts(t)
+	vm, _ := testVM(t)
+	vm.Properties["restart:max"] = "3"
+	r := vm.newRestarter()
+	if r.max != 3 || r.window != 600*time.Second {
+		t.Errorf("Unexpected restarter: %#v", r)
+	}
+
+	// Delay doubles with each restart
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		if !r.restart(VMError) {
+			t.Fatalf("Gave up after %d restarts", i)
+		}
+		if elapsed, delay := time.Since(start), restartDelay<<uint(i); elapsed < delay {
+			t.Errorf("Restart %d after %v, expected %v", i+1, elapsed, delay)
+		}
+	}
+	if !r.restart(VMRebooted) {
+		t.Error("Reboot counted as restart")
+	}
+	if r.restart(VMTripleFault) {
+		t.Error("Didn't give up after restart:max restarts")
+	}
+
+	// Restarts out of the window don't count
+	r.window = 20 * time.Millisecond
+	time.Sleep(r.window)
+	if !r.restart(VMError) {
+		t.Error("Gave up after restarts out of the window")
+	}
+}
+
+func TestRestarterStopRequested(t *testing.T) {
+	fastRestarts(t)
+	vm, _ := testVM(t)
+	r := vm.newRestarter()
+	vm.requestStop()
+	if r.restart(VMError) {
+		t.Error("Restarted VM requested to stop")
+	}
+}
@@ -0,0 +1,266 @@
+package vm
+
+import "errors"
+import "fmt"
+import "math"
+import "os"
+import "strconv"
+import "strings"
+
+type PropertyType int
+
+const (
+	StringProperty = PropertyType(iota)
+	IntProperty
+	SizeProperty
+	PathProperty
+	BoolProperty
+	EnumProperty
+)
+
+func (t PropertyType) String() string {
+	switch t {
+	case StringProperty:
+		return "string"
+	case IntProperty:
+		return "integer"
+	case SizeProperty:
+		return "size"
+	case PathProperty:
+		return "path"
+	case BoolProperty:
+		return "boolean"
+	case EnumProperty:
+		return "enum"
+	default:
+		return fmt.Sprintf("WTF%d", t)
+	}
+}
+
+func (t PropertyType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// PropertySpec describes a single bhyve:* property. Min and Max, if
+// non-zero, limit integer properties, and size properties (in Unit).
+// Values lists allowed values of enum properties. Unique properties
+// have to differ between VMs, and are not copied to clones; other
+// properties are passed through Clone, if set, when copied to a clone,
+// which returns the clone's value, or false if the property is not
+// copied. Host properties name the host's files, and are not copied to
+// VMs imported from another host. Indexed properties are a family of
+// properties named Name followed by a number (disk1, disk2, ...), and
+// prefixed ones are named Name followed by any key (bhyveload:env:KEY).
+// Values of secret properties are not shown. Check validates the
+// value's syntax, CheckKey validates KEY of a prefixed property, and
+// HostCheck (like existence of paths) checks the value against the
+// host.
+type PropertySpec struct {
+	Name      string                                  `json:"name"`
+	Type      PropertyType                            `json:"type"`
+	Min       int64                                   `json:"min,omitempty"`
+	Max       int64                                   `json:"max,omitempty"`
+	Unit      string                                  `json:"unit,omitempty"`
+	Values    []string                                `json:"values,omitempty"`
+	Help      string                                  `json:"help"`
+	Unique    bool                                    `json:"unique,omitempty"`
+	Host      bool                                    `json:"host,omitempty"`
+	Indexed   bool                                    `json:"indexed,omitempty"`
+	Prefix    bool                                    `json:"prefix,omitempty"`
+	Secret    bool                                    `json:"secret,omitempty"`
+	Check     func(ex Executor, value string) error   `json:"-"`
+	CheckKey  func(key string) error                  `json:"-"`
+	HostCheck func(ex Executor, value string) error   `json:"-"`
+	Clone     func(name, value string) (string, bool) `json:"-"`
+}
+
+func LookupProperty(name string) *PropertySpec {
+	for _, spec := range PropertySchema {
+		if spec.Indexed {
+			if _, ok := propertyIndex(spec.Name, name); ok {
+				return spec
+			}
+		} else if spec.Prefix {
+			if len(name) > len(spec.Name) && strings.HasPrefix(name, spec.Name) {
+				return spec
+			}
+		} else if spec.Name == name {
+			return spec
+		}
+	}
+	return nil
+}
+
+// propertyIndex returns index of an indexed property name, and true if
+// name is prefix followed by a number.
+func propertyIndex(prefix, name string) (int, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	suffix := name[len(prefix):]
+	if len(suffix) > 1 && suffix[0] == '0' {
+		return 0, false // disk01 would be the same as disk1
+	}
+	if idx, err := strconv.ParseUint(suffix, 10, 8); err != nil {
+		return 0, false
+	} else {
+		return int(idx), true
+	}
+}
+
+// Pattern returns the property's name, or pattern of names for indexed
+// and prefixed properties.
+func (spec *PropertySpec) Pattern() string {
+	switch {
+	case spec.Indexed:
+		return spec.Name + "<N>"
+	case spec.Prefix:
+		return spec.Name + "<KEY>"
+	default:
+		return spec.Name
+	}
+}
+
+// Validate checks that value is valid for the property on this host.
+func (spec *PropertySpec) Validate(ex Executor, value string) error {
+	if err := spec.checkSyntax(ex, value); err != nil {
+		return err
+	}
+	return spec.checkHost(ex, value)
+}
+
+// checkKey checks KEY in name of a prefixed property.
+func (spec *PropertySpec) checkKey(name string) error {
+	if spec.Prefix && spec.CheckKey != nil {
+		return spec.CheckKey(name[len(spec.Name):])
+	}
+	return nil
+}
+
+// checkSyntax checks value, without checking it against the host.
+func (spec *PropertySpec) checkSyntax(ex Executor, value string) error {
+	switch spec.Type {
+	case IntProperty:
+		if n, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("Not an integer: %#v", value)
+		} else if err := spec.checkRange(n); err != nil {
+			return err
+		}
+	case SizeProperty:
+		if n, err := ParseSize(value); err != nil {
+			return err
+		} else if err := spec.checkRange(n); err != nil {
+			return err
+		}
+	case BoolProperty:
+		if _, err := ParseBool(value); err != nil {
+			return err
+		}
+	case EnumProperty:
+		if !oneOf(value, spec.Values) {
+			return fmt.Errorf("%#v is not one of: %s", value, strings.Join(spec.Values, ", "))
+		}
+	}
+	if spec.Check != nil {
+		return spec.Check(ex, value)
+	}
+	return nil
+}
+
+// checkHost checks syntactically valid value against the host.
+func (spec *PropertySpec) checkHost(ex Executor, value string) error {
+	if spec.Type == PathProperty {
+		if _, err := os.Stat(value); err != nil {
+			return err
+		}
+	}
+	if spec.HostCheck != nil {
+		return spec.HostCheck(ex, value)
+	}
+	return nil
+}
+
+func (spec *PropertySpec) checkRange(n int64) error {
+	if spec.Min != 0 && n < spec.Min {
+		return fmt.Errorf("%d%s is less than minimum %d%s", n, spec.Unit, spec.Min, spec.Unit)
+	}
+	if spec.Max != 0 && n > spec.Max {
+		return fmt.Errorf("%d%s is more than maximum %d%s", n, spec.Unit, spec.Max, spec.Unit)
+	}
+	return nil
+}
+
+// Range returns human-readable allowed range of the property, or an
+// empty string if it is not limited.
+func (spec *PropertySpec) Range() string {
+	switch {
+	case len(spec.Values) > 0:
+		return strings.Join(spec.Values, "|")
+	case spec.Min != 0 && spec.Max != 0:
+		return fmt.Sprintf("%d%s..%d%s", spec.Min, spec.Unit, spec.Max, spec.Unit)
+	case spec.Min != 0:
+		return fmt.Sprintf(">= %d%s", spec.Min, spec.Unit)
+	case spec.Max != 0:
+		return fmt.Sprintf("<= %d%s", spec.Max, spec.Unit)
+	default:
+		return ""
+	}
+}
+
+// ParseBool parses a boolean property value.
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "on", "true", "1":
+		return true, nil
+	case "no", "off", "false", "0", "":
+		return false, nil
+	default:
+		return false, fmt.Errorf("Not a boolean: %#v", value)
+	}
+}
+
+var sizeSuffixes = map[byte]float64{
+	'K': 1.0 / 1024,
+	'M': 1,
+	'G': 1024,
+	'T': 1024 * 1024,
+}
+
+// ParseSize parses memory size with optional K, M, G or T suffix, and
+// returns it in megabytes. Size without suffix is in megabytes, same as
+// bhyve's -m switch. Sizes below a megabyte, and ones that don't fit in
+// int64 bytes, are invalid.
+func ParseSize(value string) (int64, error) {
+	num, mult := strings.TrimSpace(value), 1.0
+	if num == "" {
+		return 0, errors.New("Empty size")
+	}
+	if m, ok := sizeSuffixes[strings.ToUpper(num[len(num)-1:])[0]]; ok {
+		num, mult = num[:len(num)-1], m
+	}
+	if n, err := strconv.ParseFloat(num, 64); err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n < 0 {
+		return 0, fmt.Errorf("Invalid size: %#v", value)
+	} else if mb := n * mult; mb < 1 || mb >= math.MaxInt64>>20 {
+		return 0, fmt.Errorf("Size out of range: %#v", value)
+	} else {
+		return int64(mb), nil
+	}
+}
+
+// HostCPUs returns number of the host's CPUs.
+func HostCPUs(ex Executor) (int, error) {
+	if out, err := runStdout(ex, nil, "sysctl", "-n", "hw.ncpu"); err != nil {
+		return 0, err
+	} else {
+		return strconv.Atoi(strings.TrimSpace(out))
+	}
+}
+
+func checkHostCPUs(ex Executor, value string) error {
+	if ncpu, err := HostCPUs(ex); err != nil {
+		return err
+	} else if n, _ := strconv.Atoi(value); n > ncpu {
+		return fmt.Errorf("Host has only %d CPUs", ncpu)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package vm
+
+import "testing"
+
+func TestPropertyIndex(t *testing.T) {
+	for name, expected := range map[string]int{
+		"disk0": 0, "disk1": 1, "disk10": 10, "disk255": 255,
+		"disk": -1, "disk01": -1, "disk00": -1, "disk256": -1, "disk+1": -1, "dis": -1, "net1": -1,
+	} {
+		if idx, ok := propertyIndex("disk", name); !ok && expected >= 0 || ok && idx != expected {
+			t.Errorf("propertyIndex(%#v): %d, %v; expected %d", name, idx, ok, expected)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"512": 512, "1024K": 1, "2G": 2048, "1.5g": 1536, "1T": 1 << 20, " 64M ": 64,
+		"": -1, "512K": -1, "0": -1, "-1G": -1, "NaN": -1, "Inf": -1, "-InfM": -1, "1e30T": -1, "lots": -1,
+	} {
+		if mb, err := ParseSize(value); err != nil && expected >= 0 || err == nil && mb != expected {
+			t.Errorf("ParseSize(%#v): %d, %v; expected %d", value, mb, err, expected)
+		}
+	}
+}
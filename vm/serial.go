@@ -0,0 +1,271 @@
+package vm
+
+import "fmt"
+import "io"
+import "net"
+import "os"
+import "path/filepath"
+import "strings"
+import "sync"
+import "time"
+
+var serialPorts = []string{"com2", "com3", "com4"}
+
+// SerialPort is an additional serial port of the VM. It is attached to
+// an nmdm device pair; the host side is available to the user (nmdm
+// backend), or copied to a file (file backend) or a unix socket (unix
+// backend) by the process running the VM.
+type SerialPort struct {
+	Port    string `json:"port"`
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+	device  string // bhyve's side of nmdm
+	host    string // host side of nmdm
+}
+
+// ParseSerial parses value of a com<N> property: nmdm, file:PATH,
+// unix, or unix:PATH. Path is empty if not given.
+func ParseSerial(value string) (string, string, error) {
+	parts := strings.SplitN(value, ":", 2)
+	switch {
+	case value == "nmdm" || value == "unix":
+		return value, "", nil
+	case len(parts) == 2 && (parts[0] == "file" || parts[0] == "unix"):
+		if !filepath.IsAbs(parts[1]) {
+			return "", "", fmt.Errorf("Path %#v is not absolute", parts[1])
+		}
+		return parts[0], parts[1], nil
+	default:
+		return "", "", fmt.Errorf("Invalid serial port %#v, expected nmdm, file:PATH, unix, or unix:PATH", value)
+	}
+}
+
+func checkSerial(ex Executor, value string) error {
+	_, _, err := ParseSerial(value)
+	return err
+}
+
+// cloneSerial keeps a clone from writing to the same file, or listening
+// on the same socket, as the source VM: file ports are not copied, and
+// unix ports get the default socket in the clone's run directory.
+func cloneSerial(name, value string) (string, bool) {
+	switch backend, _, _ := ParseSerial(value); backend {
+	case "file":
+		return "", false
+	case "unix":
+		return "unix", true
+	default:
+		return value, true
+	}
+}
+
+// SerialPorts returns the VM's additional serial ports.
+func (vm *VM) SerialPorts() []*SerialPort {
+	var ports []*SerialPort
+	for _, port := range serialPorts {
+		backend, path, err := ParseSerial(vm.Property(port))
+		if err != nil {
+			continue
+		}
+		sp := &SerialPort{
+			Port:    port,
+			Backend: backend,
+			Path:    path,
+			device:  vm.nmdmPath(port + "-A"),
+			host:    vm.nmdmPath(port + "-B"),
+		}
+		switch {
+		case backend == "nmdm":
+			sp.Path = sp.host
+		case backend == "unix" && path == "":
+			sp.Path = vm.runPath(port + ".sock")
+		}
+		ports = append(ports, sp)
+	}
+	return ports
+}
+
+// serialArgs returns bhyve's switches for the VM's serial ports.
+func (vm *VM) serialArgs() []string {
+	args := []string{"-l", "com1," + vm.consoleBackend()}
+	for _, sp := range vm.SerialPorts() {
+		args = append(args, "-l", sp.Port+","+sp.device)
+	}
+	return args
+}
+
+// loadNmdm loads the nmdm kernel module, which is not loaded by default,
+// if the VM's console or serial ports need it.
+func (vm *VM) loadNmdm() error {
+	if vm.console == "" && len(vm.SerialPorts()) == 0 {
+		return nil
+	}
+	if err := run(vm.ex, nil, os.Stdout, "kldload", "-n", "nmdm"); err != nil {
+		return fmt.Errorf("Can't load nmdm kernel module, needed for the VM's console and serial ports"+
+			" (load it with nmdm_load=\"YES\" in /boot/loader.conf): %s", err)
+	}
+	return nil
+}
+
+// startSerialProxies starts copying the VM's serial ports to their
+// logs, files, and sockets, and returns function that stops it.
+func (vm *VM) startSerialProxies() (func(), error) {
+	if err := vm.loadNmdm(); err != nil {
+		return nil, err
+	}
+
+	var closers []io.Closer
+	stop := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+		vm.conlog = nil
+	}
+
+	var log io.Writer
+	if cl, err := vm.openConsoleLog(); err != nil {
+		return nil, err
+	} else if cl != nil {
+		closers = append(closers, cl)
+		log = cl
+	}
+
+	if vm.console != "" {
+		if p, err := startSerialProxy(vm.ConsoleDevice(), log, vm.ConsoleSocket()); err != nil {
+			stop()
+			return nil, err
+		} else {
+			closers = append(closers, p)
+		}
+	} else {
+		// On stdio (Run called directly, as by the packer builder),
+		// bhyve's output is logged by Load
+		vm.conlog = log
+	}
+
+	for _, sp := range vm.SerialPorts() {
+		var p *serialProxy
+		var err error
+		switch sp.Backend {
+		case "file":
+			var f *os.File
+			if f, err = os.OpenFile(sp.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640); err == nil {
+				closers = append(closers, f)
+				p, err = startSerialProxy(sp.host, f, "")
+			}
+		case "unix":
+			if err = os.MkdirAll(filepath.Dir(sp.Path), 0755); err == nil {
+				p, err = startSerialProxy(sp.host, nil, sp.Path)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("%s: %s", sp.Port, err)
+		}
+		closers = append(closers, p)
+	}
+
+	return stop, nil
+}
+
+// serialProxy copies output of the host side of an nmdm device to a log
+// and to clients connected to a unix socket, and clients' input back to
+// the device.
+type serialProxy struct {
+	dev     *os.File
+	log     io.Writer
+	l       net.Listener
+	mu      sync.Mutex
+	clients map[net.Conn]bool
+	closed  bool
+}
+
+func startSerialProxy(device string, log io.Writer, socket string) (*serialProxy, error) {
+	dev, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := makeRaw(dev.Fd()); err != nil {
+		dev.Close()
+		return nil, err
+	}
+
+	p := &serialProxy{dev: dev, log: log, clients: make(map[net.Conn]bool)}
+	if socket != "" {
+		os.Remove(socket)
+		if p.l, err = net.Listen("unix", socket); err != nil {
+			dev.Close()
+			return nil, err
+		}
+		go p.accept()
+	}
+	go p.copyOutput()
+	return p, nil
+}
+
+func (p *serialProxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *serialProxy) copyOutput() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := p.dev.Read(buf)
+		if n > 0 {
+			if p.log != nil {
+				p.log.Write(buf[:n])
+			}
+			p.mu.Lock()
+			for c := range p.clients {
+				if _, err := c.Write(buf[:n]); err != nil {
+					c.Close()
+					delete(p.clients, c)
+				}
+			}
+			p.mu.Unlock()
+		}
+		if err != nil {
+			if p.isClosed() {
+				return
+			}
+			// bhyve's side is closed between reboots
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+func (p *serialProxy) accept() {
+	for {
+		c, err := p.l.Accept()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.clients[c] = true
+		p.mu.Unlock()
+		go func() {
+			io.Copy(p.dev, c)
+			p.mu.Lock()
+			delete(p.clients, c)
+			p.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+func (p *serialProxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for c := range p.clients {
+		c.Close()
+	}
+	p.mu.Unlock()
+	if p.l != nil {
+		p.l.Close()
+	}
+	return p.dev.Close()
+}
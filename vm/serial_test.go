@@ -0,0 +1,83 @@
+package vm
+
+import "reflect"
+import "strings"
+import "testing"
+
+func TestSerialPorts(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["loader"] = "bhyveload"
+	vm.Properties["com2"] = "nmdm"
+	vm.Properties["com3"] = "file:/var/log/test.com3"
+	vm.Properties["com4"] = "unix"
+
+	var ports []string
+	for _, sp := range vm.SerialPorts() {
+		ports = append(ports, sp.Port+" "+sp.Backend+" "+sp.Path)
+	}
+	if expected := []string{
+		"com2 nmdm /dev/nmdm-test-com2-B",
+		"com3 file /var/log/test.com3",
+		"com4 unix " + vm.runPath("com4.sock"),
+	}; !reflect.DeepEqual(ports, expected) {
+		t.Errorf("Serial ports: %#v, expected %#v", ports, expected)
+	}
+
+	if err := vm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	expectArgs(t, vm, "-c 1 -m 1024 -A -P -H -l com1,stdio"+
+		" -l com2,/dev/nmdm-test-com2-A -l com3,/dev/nmdm-test-com3-A -l com4,/dev/nmdm-test-com4-A"+
+		" -s 0:0,hostbridge -s 1:0,lpc -s 2:0,virtio-blk,/dev/zvol/tank/test"+
+		" -s 3:0,virtio-net,tap0,mac="+vm.MAC()+" test")
+}
+
+func TestParseSerial(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"nmdm":           true,
+		"unix":           true,
+		"unix:/tmp/sock": true,
+		"file:/tmp/log":  true,
+		"file":           false,
+		"file:log":       false,
+		"tcp:1234":       false,
+	} {
+		if _, _, err := ParseSerial(value); (err == nil) != valid {
+			t.Errorf("%s: valid=%v, got error %v", value, valid, err)
+		}
+	}
+}
+
+func TestCloneSerial(t *testing.T) {
+	for value, expected := range map[string]string{
+		"nmdm":           "nmdm",
+		"unix":           "unix",
+		"unix:/tmp/sock": "unix",
+		"file:/tmp/log":  "",
+	} {
+		if cloned, ok := cloneSerial("com2", value); cloned != expected || ok != (expected != "") {
+			t.Errorf("%s: expected %#v, got %#v, %v", value, expected, cloned, ok)
+		}
+	}
+}
+
+func TestLoadNmdm(t *testing.T) {
+	vm, ex := testVM(t)
+	if err := vm.loadNmdm(); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "kldload")
+
+	vm.Properties["com2"] = "nmdm"
+	if err := vm.loadNmdm(); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "kldload", "kldload -n nmdm")
+
+	delete(vm.Properties, "com2")
+	vm.console = vm.nmdmPath("A")
+	ex.On("kldload").Exit(1)
+	if err := vm.loadNmdm(); err == nil || !strings.Contains(err.Error(), "nmdm kernel module") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package vm
+
+import "fmt"
+import "os"
+import "path/filepath"
+import "regexp"
+import "sort"
+import "strings"
+
+const sharePrefix = "share:"
+
+// Valid share tags and channel names, within virtio-9p's tag length
+// limit
+var rxTag = regexp.MustCompile(`^[a-z0-9_.-]{1,255}$`)
+
+// checkTag checks share tag or channel name, which is KEY of the share:
+// or channel: property.
+func checkTag(key string) error {
+	if !rxTag.MatchString(key) {
+		return fmt.Errorf("Invalid tag %#v, expected up to 255 characters of a-z, 0-9, _, . and -", key)
+	}
+	return nil
+}
+
+// Share is a host directory shared with the VM over virtio-9p.
+type Share struct {
+	Tag      string `json:"tag"`
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"ro,omitempty"`
+}
+
+// ParseShare parses value of a share:TAG property: PATH[,ro].
+func ParseShare(value string) (*Share, error) {
+	parts := strings.Split(value, ",")
+	share := &Share{Path: parts[0]}
+	if !filepath.IsAbs(share.Path) {
+		return nil, fmt.Errorf("Shared path %#v is not absolute", share.Path)
+	}
+	for _, part := range parts[1:] {
+		if part == "ro" {
+			share.ReadOnly = true
+		} else {
+			return nil, fmt.Errorf("Invalid share option %#v", part)
+		}
+	}
+	return share, nil
+}
+
+func checkShare(ex Executor, value string) error {
+	_, err := ParseShare(value)
+	return err
+}
+
+func checkShareHost(ex Executor, value string) error {
+	share, _ := ParseShare(value)
+	if fi, err := os.Stat(share.Path); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", share.Path)
+	}
+	return nil
+}
+
+// Device returns bhyve's device specification of the share (without
+// the slot).
+func (s *Share) Device() string {
+	dev := "virtio-9p," + s.Tag + "=" + s.Path
+	if s.ReadOnly {
+		dev += ",ro"
+	}
+	return dev
+}
+
+// Shares returns the VM's shared directories configured with share:TAG
+// properties, ordered by tag.
+func (vm *VM) Shares() ([]*Share, error) {
+	var shares []*Share
+	for name, value := range vm.Properties {
+		if !strings.HasPrefix(name, sharePrefix) {
+			continue
+		}
+		tag := name[len(sharePrefix):]
+		if share, err := ParseShare(value); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		} else {
+			share.Tag = tag
+			shares = append(shares, share)
+		}
+	}
+	sort.Sort(sharesByTag(shares))
+	return shares, nil
+}
+
+type sharesByTag []*Share
+
+func (ss sharesByTag) Len() int           { return len(ss) }
+func (ss sharesByTag) Less(i, j int) bool { return ss[i].Tag < ss[j].Tag }
+func (ss sharesByTag) Swap(i, j int)      { ss[i], ss[j] = ss[j], ss[i] }
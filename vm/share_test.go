@@ -0,0 +1,64 @@
+package vm
+
+import "reflect"
+import "strings"
+import "testing"
+
+func TestShares(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["share:src"] = "/usr/src,ro"
+	vm.Properties["share:home"] = "/home"
+
+	shares, err := vm.Shares()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var devices []string
+	for _, share := range shares {
+		devices = append(devices, share.Device())
+	}
+	if expected := []string{"virtio-9p,home=/home", "virtio-9p,src=/usr/src,ro"}; !reflect.DeepEqual(devices, expected) {
+		t.Errorf("Shares: %#v, expected %#v", devices, expected)
+	}
+}
+
+// Invalid tags are rejected when the property is set, not when the VM
+// is started.
+func TestShareTags(t *testing.T) {
+	vm, ex := testVM(t)
+	dir := t.TempDir()
+	for tag, valid := range map[string]bool{
+		"src":                    true,
+		"org.freebsd_9p-0":       true,
+		"Bad":                    false,
+		"bad tag":                false,
+		"bad=tag":                false,
+		"bad,tag":                false,
+		strings.Repeat("t", 255): true,
+		strings.Repeat("t", 256): false,
+	} {
+		if err := vm.SetProperties(map[string]string{"share:" + tag: dir}); (err == nil) != valid {
+			t.Errorf("share:%s: valid=%v, got error %v", tag, valid, err)
+		}
+	}
+	if sets := len(commandsOf(ex, "zfs")); sets != 3 {
+		t.Errorf("Set %d shares, expected 3", sets)
+	}
+}
+
+func TestShareValidation(t *testing.T) {
+	ex := NewFakeExecutor()
+	dir := t.TempDir()
+	for value, valid := range map[string]bool{
+		dir:                  true,
+		dir + ",ro":          true,
+		dir + ",rw":          false,
+		"relative/path":      false,
+		dir + "/nonexisting": false,
+		"/dev/null":          false, // not a directory
+	} {
+		if err := ValidateProperty(ex, "share:test", value); (err == nil) != valid {
+			t.Errorf("share:test=%s: valid=%v, got error %v", value, valid, err)
+		}
+	}
+}
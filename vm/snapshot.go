@@ -0,0 +1,178 @@
+package vm
+
+import "errors"
+import "fmt"
+import "os"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+
+var ErrRunning = errors.New("VM is running")
+
+type Snapshot struct {
+	Name       string            `json:"name"`
+	Created    time.Time         `json:"created"`
+	Used       int64             `json:"used"`
+	Properties map[string]string `json:"properties"`
+}
+
+func (vm *VM) snapshotPath(name string) string {
+	return vm.Volume + "@" + name
+}
+
+// localProperties returns sorted names of properties set locally on the
+// VM's volume.
+func (vm *VM) localProperties() []string {
+	var names []string
+	for name := range vm.Properties {
+		if vm.PropertySource(name) == "local" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Snapshot takes a ZFS snapshot of the VM's volume. Properties set on
+// the volume are recorded on the snapshot, so that Rollback can restore
+// them. If name is empty, current time is used.
+func (vm *VM) Snapshot(name string) (string, error) {
+	if name == "" {
+		name = time.Now().Format("20060102-150405")
+	}
+	if !validName(name) {
+		return "", fmt.Errorf("Invalid snapshot name: %#v", name)
+	}
+
+	args := []string{"snapshot"}
+	for _, prop := range vm.localProperties() {
+		args = append(args, "-o", "bhyve:"+prop+"="+vm.Properties[prop])
+	}
+	args = append(args, vm.snapshotPath(name))
+
+	if err := run(vm.ex, nil, os.Stdout, "zfs", args...); err != nil {
+		return "", err
+	}
+	return name, vm.saveUEFIVars(name)
+}
+
+// Snapshots lists the VM's snapshots, oldest first. Values of secret
+// properties recorded on the snapshots are hidden.
+func (vm *VM) Snapshots() ([]*Snapshot, error) {
+	lines, err := zfs_peek(vm.ex, "list", "-p", "-t", "snapshot", "-d", "1",
+		"-s", "creation", "-o", "name,creation,used", vm.Volume)
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]*Snapshot, 0, len(lines))
+	for _, line := range lines {
+		if len(line) < 3 {
+			continue
+		}
+		snap := &Snapshot{Name: strings.SplitN(line[0], "@", 2)[1]}
+		if created, err := strconv.ParseInt(line[1], 10, 64); err == nil {
+			snap.Created = time.Unix(created, 0)
+		}
+		snap.Used, _ = strconv.ParseInt(line[2], 10, 64)
+		if snap.Properties, err = vm.snapshotProperties(snap.Name); err != nil {
+			return nil, err
+		}
+		for name, value := range snap.Properties {
+			snap.Properties[name] = displayValue(name, value)
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+func (vm *VM) snapshotProperties(name string) (map[string]string, error) {
+	lines, err := zfs_peek(vm.ex, "get", "-s", "local", "-o", "property,value", "all", vm.snapshotPath(name))
+	if err != nil {
+		return nil, err
+	}
+	props := make(map[string]string)
+	for _, line := range lines {
+		if strings.HasPrefix(line[0], "bhyve:") {
+			props[line[0][6:]] = line[1]
+		}
+	}
+	return props, nil
+}
+
+// Rollback rolls the VM's volume back to the named snapshot, and
+// restores properties recorded on it. Unless force is true, it refuses
+// to roll back a running VM. If recursive is true, snapshots newer than
+// the named one are destroyed.
+func (vm *VM) Rollback(name string, force, recursive bool) error {
+	if !validName(name) {
+		return fmt.Errorf("Invalid snapshot name: %#v", name)
+	}
+	if vm.Exists() && !force {
+		return ErrRunning
+	}
+
+	props, err := vm.snapshotProperties(name)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"rollback"}
+	if recursive {
+		args = append(args, "-r")
+	}
+	if err := run(vm.ex, nil, os.Stdout, "zfs", append(args, vm.snapshotPath(name))...); err != nil {
+		return err
+	}
+	if err := vm.restoreUEFIVars(name); err != nil {
+		return err
+	}
+	if recursive {
+		if err := vm.pruneUEFIVars(); err != nil {
+			return err
+		}
+	}
+
+	if len(props) == 0 {
+		// Snapshot not taken by Snapshot, nothing to restore
+		return nil
+	}
+
+	for _, prop := range vm.localProperties() {
+		if _, recorded := props[prop]; !recorded && prop != "name" {
+			if err := run(vm.ex, nil, os.Stdout, "zfs", "inherit", "bhyve:"+prop, vm.Volume); err != nil {
+				return err
+			}
+		}
+	}
+	delete(props, "name")
+	names := make([]string, 0, len(props))
+	for prop := range props {
+		names = append(names, prop)
+	}
+	sort.Strings(names)
+	args = []string{"set"}
+	for _, prop := range names {
+		args = append(args, "bhyve:"+prop+"="+props[prop])
+	}
+	if err := run(vm.ex, nil, os.Stdout, "zfs", append(args, vm.Volume)...); err != nil {
+		return err
+	}
+	return vm.LoadProperties()
+}
+
+func (vm *VM) DestroySnapshot(name string) error {
+	if !validName(name) {
+		return fmt.Errorf("Invalid snapshot name: %#v", name)
+	}
+	if err := run(vm.ex, nil, os.Stdout, "zfs", "destroy", vm.snapshotPath(name)); err != nil {
+		return err
+	}
+	if vm.filesystem {
+		return nil
+	}
+	if err := os.Remove(vm.uefiVarsPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
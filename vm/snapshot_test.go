@@ -0,0 +1,31 @@
+package vm
+
+import "testing"
+
+func TestRollback(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["cpus"] = "4"
+	vm.Properties["mem"] = "2G"
+	ex.On("zfs", "get", "-H", "-s", "local", "-o", "property,value", "all", "tank/test@base").
+		Output("bhyve:name\ttest\nbhyve:bridge\tbktest0\nbhyve:cpus\t2\nbhyve:loader\tuefi\n")
+
+	if err := vm.Rollback("base", false, false); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "zfs",
+		"zfs get -H -s local -o property,value all tank/test@base",
+		"zfs rollback tank/test@base",
+		"zfs inherit bhyve:mem tank/test",
+		"zfs set bhyve:bridge=bktest0 bhyve:cpus=2 bhyve:loader=uefi tank/test",
+		"zfs get -H -o property,value,source all tank/test")
+}
+
+func TestRollbackInvalidName(t *testing.T) {
+	vm, ex := testVM(t)
+	for _, name := range []string{"", "base -r", "other@base", "tank/other@base"} {
+		if err := vm.Rollback(name, false, false); err == nil {
+			t.Errorf("Rolled back to %#v", name)
+		}
+	}
+	expectCommands(t, ex, "zfs")
+}
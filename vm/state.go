@@ -0,0 +1,77 @@
+package vm
+
+import "encoding/json"
+import "io/ioutil"
+import "os"
+import "syscall"
+import "time"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+// State is the runtime state record of a running VM, kept in the VM's
+// run directory by the process running it (Runner), also while it waits
+// to restart the VM.
+type State struct {
+	Runner    int       `json:"runner"`
+	Pid       int       `json:"pid"`
+	Taps      []string  `json:"taps,omitempty"`
+	Bridges   []string  `json:"bridges,omitempty"` // of the taps
+	Console   string    `json:"console"`
+	VNC       string    `json:"vnc,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Boots     int       `json:"boots"`
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+func (vm *VM) statePath() string {
+	return vm.runPath("state.json")
+}
+
+// State reads the VM's runtime state record. It returns nil if there
+// is no record, or if the process that wrote it is gone.
+func (vm *VM) State() *State {
+	buf, err := ioutil.ReadFile(vm.statePath())
+	if err != nil {
+		return nil
+	}
+	var st State
+	if err := json.Unmarshal(buf, &st); err != nil {
+		cli.Error(err)
+		return nil
+	}
+	if st.Runner == 0 || !processAlive(st.Runner) {
+		return nil
+	}
+	return &st
+}
+
+func (vm *VM) saveState() {
+	if err := os.MkdirAll(vm.runPath(""), 0755); err != nil {
+		cli.Error(err)
+		return
+	}
+	buf, err := json.Marshal(vm.state)
+	if err != nil {
+		cli.Error(err)
+		return
+	}
+	tmp := vm.statePath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		cli.Error(err)
+		return
+	}
+	if err := os.Rename(tmp, vm.statePath()); err != nil {
+		cli.Error(err)
+	}
+}
+
+func (vm *VM) clearState() {
+	vm.state = nil
+	if err := os.Remove(vm.statePath()); err != nil && !os.IsNotExist(err) {
+		cli.Error(err)
+	}
+}
@@ -0,0 +1,55 @@
+package vm
+
+import "io/ioutil"
+import "os"
+import "testing"
+
+// Run must not touch a VM that is held by another runner, or that
+// exists without one (e.g. started by hand).
+func TestRunAlreadyRunning(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.state = &State{Runner: os.Getppid()}
+	vm.saveState()
+	vm.state = nil
+
+	if err := vm.Run(); err != ErrAlreadyRunning {
+		t.Errorf("Run with another runner: expected ErrAlreadyRunning, got %v", err)
+	}
+	if st := vm.State(); st == nil || st.Runner != os.Getppid() {
+		t.Errorf("State of the other runner was overwritten: %#v", st)
+	}
+
+	vm.clearState()
+	if err := ioutil.WriteFile(vm.vmmPath(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Run(); err != ErrAlreadyRunning {
+		t.Errorf("Run of existing VM: expected ErrAlreadyRunning, got %v", err)
+	}
+	if cmds := ex.Commands(); len(cmds) != 0 {
+		t.Errorf("Unexpected commands: %#v", cmds)
+	}
+}
+
+func TestBhyvePid(t *testing.T) {
+	vm, ex := testVM(t)
+	if pid := vm.BhyvePid(); pid != 0 {
+		t.Errorf("BhyvePid of nonexistent VM: %d", pid)
+	}
+
+	if err := ioutil.WriteFile(vm.vmmPath(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ex.On("fuser").Output(" 1234 5678\n")
+	if pid := vm.BhyvePid(); pid != 1234 {
+		t.Errorf("BhyvePid from fuser: %d", pid)
+	}
+	expectCommands(t, ex, "fuser", "fuser "+vm.vmmPath())
+
+	vm.state = &State{Runner: os.Getpid(), Pid: os.Getpid()}
+	vm.saveState()
+	if pid := vm.BhyvePid(); pid != os.Getpid() {
+		t.Errorf("BhyvePid from state: %d", pid)
+	}
+	expectCommands(t, ex, "fuser", "fuser "+vm.vmmPath())
+}
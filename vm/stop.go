@@ -0,0 +1,69 @@
+package vm
+
+import "errors"
+import "time"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+var ErrNotRunning = errors.New("VM is not running")
+
+// How often Stop checks whether bhyve has exited
+var stopPollInterval = time.Second
+
+// Stop shuts the VM down. Unless force is true, bhyve is first sent
+// SIGTERM, which it turns into an ACPI power button press, and the guest
+// is given timeout to power itself off. After that, or right away when
+// forced, the VM is powered off. The process running the VM destroys it
+// and its taps; if there is none, Stop does. The VM is not restarted
+// regardless of its restart property.
+func (vm *VM) Stop(timeout time.Duration, force bool) error {
+	if !vm.Exists() {
+		// Run loop may be waiting to restart it
+		if vm.runnerPid() != 0 {
+			vm.requestStop()
+			return nil
+		}
+		return ErrNotRunning
+	}
+	vm.requestStop()
+
+	runner := vm.runnerPid() != 0
+	if !runner {
+		// Find taps before bhyve exits, so that they can be cleaned up
+		vm.Taps(false)
+	}
+
+	if pid := vm.BhyvePid(); pid != 0 && !force {
+		cli.Infof("Sending ACPI shutdown to %s (pid %d)", vm.Name, pid)
+		if err := vm.Poweroff(); err != nil {
+			return err
+		}
+		if vm.waitForExit(timeout) {
+			if !runner {
+				vm.Destroy()
+			}
+			return nil
+		}
+		cli.Infof("%s did not stop within %v, powering off", vm.Name, timeout)
+	}
+
+	if vm.Exists() {
+		if err := vm.RunBhyvectl("--force-poweroff"); err != nil {
+			return err
+		}
+	}
+	if !runner {
+		vm.Destroy()
+	}
+	return nil
+}
+
+func (vm *VM) waitForExit(timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		time.Sleep(stopPollInterval)
+		if !vm.Exists() || vm.BhyvePid() == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package vm
+
+import "io/ioutil"
+import "os"
+import "testing"
+import "time"
+
+func TestStopNotRunning(t *testing.T) {
+	vm, _ := testVM(t)
+	if err := vm.Stop(time.Second, false); err != ErrNotRunning {
+		t.Errorf("Stop: expected ErrNotRunning, got %v", err)
+	}
+	if err := vm.Poweroff(); err != ErrNotRunning {
+		t.Errorf("Poweroff: expected ErrNotRunning, got %v", err)
+	}
+}
+
+// A VM waiting in a foreground Run loop to be restarted has no bhyve
+// process and no supervisor, only the state record.
+func TestStopWaitingToRestart(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.state = &State{Runner: os.Getpid()}
+	vm.saveState()
+
+	if err := vm.Stop(time.Second, false); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if !vm.stopRequested() {
+		t.Error("Stop didn't request stop")
+	}
+
+	if err := vm.Poweroff(); err != nil {
+		t.Errorf("Poweroff: %v", err)
+	}
+	if !vm.stopRequested() {
+		t.Error("Poweroff didn't request stop")
+	}
+	expectCommands(t, ex, "kill")
+}
+
+// runningVM makes the test VM look like it's running, with bhyve as
+// pid 1234, and run by this process if runner is true.
+func runningVM(t *testing.T, runner bool) (*VM, *FakeExecutor) {
+	vm, ex := testVM(t)
+	if err := ioutil.WriteFile(vm.vmmPath(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if runner {
+		vm.state = &State{Runner: os.Getpid(), Pid: 1234, Taps: []string{"tap0"}}
+		vm.saveState()
+	} else {
+		ex.On("fuser").Output("1234\n")
+		ex.On("fstat").Output("root bhyve 1234 11 /dev 95 crw------- tap0 rw\n")
+	}
+
+	interval := stopPollInterval
+	stopPollInterval = time.Millisecond
+	t.Cleanup(func() { stopPollInterval = interval })
+	return vm, ex
+}
+
+func TestStopACPI(t *testing.T) {
+	vm, ex := runningVM(t, true)
+	ex.On("kill").Do(func(*Cmd) error { return os.Remove(vm.vmmPath()) })
+
+	if err := vm.Stop(time.Second, false); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "kill", "kill -TERM 1234")
+	expectCommands(t, ex, "bhyvectl")
+	if !vm.stopRequested() {
+		t.Error("Stop didn't request stop")
+	}
+}
+
+// A guest that ignores ACPI shutdown is powered off after the timeout;
+// the runner destroys it.
+func TestStopTimeout(t *testing.T) {
+	vm, ex := runningVM(t, true)
+
+	start := time.Now()
+	if err := vm.Stop(20*time.Millisecond, false); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Powered off after %v, before timeout", elapsed)
+	}
+	expectCommands(t, ex, "kill", "kill -TERM 1234")
+	expectCommands(t, ex, "bhyvectl", "bhyvectl --vm=test --force-poweroff")
+	expectCommands(t, ex, "ifconfig")
+}
+
+func TestStopForce(t *testing.T) {
+	vm, ex := runningVM(t, true)
+	if err := vm.Stop(time.Minute, true); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "kill")
+	expectCommands(t, ex, "bhyvectl", "bhyvectl --vm=test --force-poweroff")
+	expectCommands(t, ex, "ifconfig")
+}
+
+// A VM without a process running it is destroyed by Stop.
+func TestStopWithoutRunner(t *testing.T) {
+	vm, ex := runningVM(t, false)
+	ex.On("bhyvectl", "--vm=test", "--destroy").Do(func(*Cmd) error { return os.Remove(vm.vmmPath()) })
+
+	if err := vm.Stop(time.Minute, true); err != nil {
+		t.Fatal(err)
+	}
+	expectCommands(t, ex, "bhyvectl",
+		"bhyvectl --vm=test --force-poweroff",
+		"bhyvectl --vm=test --destroy")
+	// Bridges of taps found with fstat are unknown, destroying the tap
+	// takes it off its bridge
+	expectCommands(t, ex, "ifconfig", "ifconfig tap0 destroy")
+}
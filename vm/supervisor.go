@@ -0,0 +1,163 @@
+package vm
+
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "strconv"
+import "strings"
+import "syscall"
+import "time"
+
+import "github.com/3ofcoins/bheekeeper/cli"
+
+var ErrAlreadyRunning = errors.New("VM is already running")
+
+func (vm *VM) pidfilePath() string {
+	return vm.runPath("supervisor.pid")
+}
+
+// ConsoleDevice returns path of the host side of the nmdm device pair
+// that a VM's proxied console is attached to.
+func (vm *VM) ConsoleDevice() string {
+	return vm.nmdmPath("B")
+}
+
+// ConsoleSocket returns path of the unix socket where the proxied
+// console of a running VM is served.
+func (vm *VM) ConsoleSocket() string {
+	return vm.runPath("console.sock")
+}
+
+func (vm *VM) nmdmPath(side string) string {
+	return fmt.Sprintf("/dev/nmdm-%s-%s", vm.Name, side)
+}
+
+// SupervisorPid returns PID of the VM's background supervisor, or zero
+// if it's not running.
+func (vm *VM) SupervisorPid() int {
+	buf, err := ioutil.ReadFile(vm.pidfilePath())
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	if !processAlive(pid) {
+		return 0 // stale pidfile
+	}
+	return pid
+}
+
+// How long Detach waits for bhyve to start
+var detachTimeout = 10 * time.Second
+
+// Detach starts command in background, in a new session and with output
+// going to the supervisor log. The command is expected to call
+// Supervise. Detach waits until bhyve is started, and fails if the
+// command exits before that; if the loader takes longer than
+// detachTimeout, the VM is assumed to be starting.
+func (vm *VM) Detach(command string, args ...string) (int, error) {
+	if vm.runnerPid() != 0 || vm.Exists() {
+		return 0, ErrAlreadyRunning
+	}
+
+	if err := os.MkdirAll(vm.runPath(""), 0755); err != nil {
+		return 0, err
+	}
+
+	log, err := os.OpenFile(vm.runPath("supervisor.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer log.Close()
+
+	c := cmd(nil, log, command, args...)
+	c.Stderr = log
+	c.Detach = true
+
+	cli.Debugf("+ %s %v &", command, args)
+	proc, err := vm.ex.Start(c)
+	if err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- proc.Wait() }()
+	for deadline := time.Now().Add(detachTimeout); time.Now().Before(deadline); {
+		if st := vm.State(); st != nil && st.Pid != 0 {
+			break
+		}
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("exited")
+			}
+			return 0, fmt.Errorf("%s failed to start (%s), see %s", vm.Name, err, log.Name())
+		case <-time.After(stopPollInterval):
+		}
+	}
+	return proc.Pid(), nil
+}
+
+// lockPidfile creates the supervisor's pidfile, locked for as long as
+// the supervisor runs, so that no other supervisor can run the VM.
+func (vm *VM) lockPidfile() (*os.File, error) {
+	for {
+		f, err := os.OpenFile(vm.pidfilePath(), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, ErrAlreadyRunning
+			}
+			return nil, err
+		}
+
+		// Previous supervisor may have removed the pidfile after it
+		// was opened here
+		if fi, err := f.Stat(); err != nil {
+			f.Close()
+			return nil, err
+		} else if cur, err := os.Stat(f.Name()); err != nil || !os.SameFile(fi, cur) {
+			f.Close()
+			continue
+		}
+
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+}
+
+// Supervise runs the VM with its console proxied to ConsoleSocket,
+// keeping a locked pidfile for as long as it runs.
+func (vm *VM) Supervise() error {
+	if err := os.MkdirAll(vm.runPath(""), 0755); err != nil {
+		return err
+	}
+
+	pidfile, err := vm.lockPidfile()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Removed before unlocking, so that the next supervisor
+		// doesn't lock a removed file
+		os.Remove(pidfile.Name())
+		pidfile.Close()
+	}()
+
+	vm.console = vm.nmdmPath("A")
+	cli.Infof("Supervising %s, console at %s", vm.Name, vm.ConsoleSocket())
+	return vm.Run()
+}
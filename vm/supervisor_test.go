@@ -0,0 +1,74 @@
+package vm
+
+import "os"
+import "strings"
+import "testing"
+
+func TestDetach(t *testing.T) {
+	vm, ex := testVM(t)
+	ex.On("bheekeeper").Do(func(c *Cmd) error {
+		vm.state = &State{Runner: os.Getpid(), Pid: 1234}
+		vm.saveState()
+		return nil
+	})
+
+	if pid, err := vm.Detach("bheekeeper", "run", "-supervise", "test"); err != nil {
+		t.Fatal(err)
+	} else if pid == 0 {
+		t.Error("No supervisor pid")
+	}
+	if calls := callsOf(ex, "bheekeeper"); len(calls) != 1 || !calls[0].Detach {
+		t.Errorf("Unexpected calls: %#v", ex.Commands())
+	}
+}
+
+// Errors of the supervisor, such as invalid properties, end up in its
+// log, so Detach has to notice it exited.
+func TestDetachFailure(t *testing.T) {
+	vm, ex := testVM(t)
+	ex.On("bheekeeper").Exit(2)
+
+	if _, err := vm.Detach("bheekeeper", "run", "-supervise", "test"); err == nil || !strings.Contains(err.Error(), "supervisor.log") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestDetachRunning(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.state = &State{Runner: os.Getppid()}
+	vm.saveState()
+
+	if _, err := vm.Detach("bheekeeper", "run", "-supervise", "test"); err != ErrAlreadyRunning {
+		t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+	}
+	expectCommands(t, ex, "bheekeeper")
+}
+
+func TestLockPidfile(t *testing.T) {
+	vm, _ := testVM(t)
+	if err := os.MkdirAll(vm.runPath(""), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pidfile, err := vm.lockPidfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := vm.SupervisorPid(); pid != os.Getpid() {
+		t.Errorf("Supervisor pid %d, expected %d", pid, os.Getpid())
+	}
+	if _, err := vm.lockPidfile(); err != ErrAlreadyRunning {
+		t.Errorf("Locked pidfile twice: %v", err)
+	}
+	if err := vm.Supervise(); err != ErrAlreadyRunning {
+		t.Errorf("Started second supervisor: %v", err)
+	}
+
+	os.Remove(pidfile.Name())
+	pidfile.Close()
+	if pidfile, err := vm.lockPidfile(); err != nil {
+		t.Errorf("Pidfile not locked again: %v", err)
+	} else {
+		pidfile.Close()
+	}
+}
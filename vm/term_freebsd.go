@@ -0,0 +1,38 @@
+//go:build freebsd
+// +build freebsd
+
+package vm
+
+import "syscall"
+import "unsafe"
+
+func termios(fd, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw puts terminal fd in raw mode, and returns a function that
+// restores its previous mode.
+func makeRaw(fd uintptr) (func() error, error) {
+	var orig syscall.Termios
+	if err := termios(fd, syscall.TIOCGETA, &orig); err != nil {
+		return nil, err
+	}
+
+	raw := orig
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := termios(fd, syscall.TIOCSETA, &raw); err != nil {
+		return nil, err
+	}
+
+	return func() error { return termios(fd, syscall.TIOCSETA, &orig) }, nil
+}
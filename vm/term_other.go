@@ -0,0 +1,10 @@
+//go:build !freebsd
+// +build !freebsd
+
+package vm
+
+import "errors"
+
+func makeRaw(fd uintptr) (func() error, error) {
+	return nil, errors.New("Raw terminal mode is supported only on FreeBSD")
+}
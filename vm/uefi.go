@@ -0,0 +1,143 @@
+package vm
+
+import "io"
+import "os"
+import "path/filepath"
+
+// Directory with bhyve's UEFI firmware, as installed by the
+// bhyve-firmware package.
+var UEFIFirmwareDir = "/usr/local/share/uefi-firmware"
+
+func (vm *VM) uefiFirmware() string {
+	if fw := vm.Property("uefi:firmware"); fw != "" {
+		return fw
+	}
+	if vm.Property("loader") == "uefi-csm" {
+		return filepath.Join(UEFIFirmwareDir, "BHYVE_UEFI_CSM.fd")
+	}
+	return filepath.Join(UEFIFirmwareDir, "BHYVE_UEFI.fd")
+}
+
+const uefiVarsFile = "uefi-vars.fd"
+
+// uefiVarsPath returns path to the VM's UEFI variables file, or to its
+// copy saved with the named snapshot. A filesystem VM keeps the file in
+// its dataset, so that it is snapshotted, cloned and exported with the
+// boot disk. A volume can't hold it, so it is kept in the VM's data
+// directory, and copied along explicitly.
+func (vm *VM) uefiVarsPath(snapshot string) string {
+	switch {
+	case vm.filesystem:
+		return filepath.Join(vm.mountpoint, uefiVarsFile)
+	case snapshot == "":
+		return vm.dataPath(uefiVarsFile)
+	default:
+		return vm.dataPath("uefi-vars@" + snapshot + ".fd")
+	}
+}
+
+// uefiVars returns path to the VM's persistent UEFI variables file,
+// creating it from firmware's template when it doesn't exist yet.
+func (vm *VM) uefiVars() (string, error) {
+	path := vm.uefiVarsPath("")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	tpl := filepath.Join(UEFIFirmwareDir, "BHYVE_UEFI_VARS.fd")
+	if _, err := os.Stat(tpl); err != nil {
+		return "", err
+	}
+	return path, copyFile(tpl, path)
+}
+
+// copyFile copies src to dst, creating dst's directory if needed. It
+// does nothing if src doesn't exist.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// saveUEFIVars copies a volume VM's UEFI variables, if there are any,
+// to go with the named snapshot.
+func (vm *VM) saveUEFIVars(snapshot string) error {
+	if vm.filesystem {
+		return nil
+	}
+	return copyFile(vm.uefiVarsPath(""), vm.uefiVarsPath(snapshot))
+}
+
+// restoreUEFIVars brings back a volume VM's UEFI variables saved with
+// the named snapshot, if there are any.
+func (vm *VM) restoreUEFIVars(snapshot string) error {
+	if vm.filesystem {
+		return nil
+	}
+	return copyFile(vm.uefiVarsPath(snapshot), vm.uefiVarsPath(""))
+}
+
+// pruneUEFIVars removes a volume VM's UEFI variables saved with
+// snapshots that no longer exist.
+func (vm *VM) pruneUEFIVars() error {
+	if vm.filesystem {
+		return nil
+	}
+	saved, err := filepath.Glob(vm.uefiVarsPath("*"))
+	if err != nil || len(saved) == 0 {
+		return err
+	}
+	snaps, err := vm.Snapshots()
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool, len(snaps))
+	for _, snap := range snaps {
+		keep[vm.uefiVarsPath(snap.Name)] = true
+	}
+	for _, path := range saved {
+		if !keep[path] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bootromArgs returns bhyve's switches that boot the VM with UEFI
+// firmware.
+func (vm *VM) bootromArgs() ([]string, error) {
+	bootrom := vm.uefiFirmware()
+	if _, err := os.Stat(bootrom); err != nil {
+		return nil, err
+	}
+	if withVars, _ := ParseBool(vm.Property("uefi:vars")); withVars {
+		if vars, err := vm.uefiVars(); err != nil {
+			return nil, err
+		} else {
+			bootrom += "," + vars
+		}
+	}
+	return []string{"-l", "bootrom," + bootrom}, nil
+}
@@ -0,0 +1,105 @@
+package vm
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+
+func writeUEFIVars(t *testing.T, path, content string) {
+	t.Helper()
+	if err := copyFile("/dev/null", path); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectUEFIVars(t *testing.T, path, expected string) {
+	t.Helper()
+	if buf, err := ioutil.ReadFile(path); err != nil {
+		t.Error(err)
+	} else if string(buf) != expected {
+		t.Errorf("%s: %#v, expected %#v", path, string(buf), expected)
+	}
+}
+
+func TestUEFIVarsSnapshots(t *testing.T) {
+	vm, ex := testVM(t)
+	writeUEFIVars(t, vm.uefiVarsPath(""), "before")
+
+	if _, err := vm.Snapshot("base"); err != nil {
+		t.Fatal(err)
+	}
+	expectUEFIVars(t, vm.uefiVarsPath("base"), "before")
+
+	writeUEFIVars(t, vm.uefiVarsPath(""), "after")
+	if _, err := vm.Snapshot("newer"); err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := vm.Clone("base", "copy", "tank/copy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUEFIVars(t, clone.uefiVarsPath(""), "before")
+
+	ex.On("zfs", "list", "-H", "-p", "-t", "snapshot").Output("tank/test@base\t1500000000\t0\n")
+	if err := vm.Rollback("base", false, true); err != nil {
+		t.Fatal(err)
+	}
+	expectUEFIVars(t, vm.uefiVarsPath(""), "before")
+	if _, err := os.Stat(vm.uefiVarsPath("newer")); !os.IsNotExist(err) {
+		t.Errorf("UEFI variables of destroyed snapshot are kept: %v", err)
+	}
+
+	if err := vm.DestroySnapshot("base"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(vm.uefiVarsPath("base")); !os.IsNotExist(err) {
+		t.Errorf("UEFI variables of destroyed snapshot are kept: %v", err)
+	}
+}
+
+func TestUEFIVarsExport(t *testing.T) {
+	vm, ex := testVM(t)
+	writeUEFIVars(t, vm.uefiVarsPath(""), "nvram")
+
+	var buf bytes.Buffer
+	if err := vm.Export(&buf, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ex.On("zpool", "list").Output("tank\n")
+	imported, err := Import(ex, &buf, "copy", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUEFIVars(t, imported.uefiVarsPath(""), "nvram")
+}
+
+func TestLoadUEFI(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["loader"] = "uefi"
+	vm.Properties["uefi:vars"] = "yes"
+	vm.Properties["vnc"] = "yes"
+	vm.Properties["vnc:port"] = "5999"
+
+	firmware := filepath.Join(UEFIFirmwareDir, "BHYVE_UEFI.fd")
+	for _, fd := range []string{firmware, filepath.Join(UEFIFirmwareDir, "BHYVE_UEFI_VARS.fd")} {
+		if err := ioutil.WriteFile(fd, []byte("firmware"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := vm.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectCommands(t, ex, "grub-bhyve")
+	expectArgs(t, vm, "-c 1 -m 1024 -A -P -H -l com1,stdio -l bootrom,"+firmware+","+vm.dataPath("uefi-vars.fd")+
+		" -s 0:0,hostbridge -s 1:0,lpc -s 2:0,virtio-blk,/dev/zvol/tank/test"+
+		" -s 3:0,virtio-net,tap0,mac="+vm.MAC()+
+		" -s 4:0,fbuf,tcp=127.0.0.1:5999,w=1024,h=768 -s 5:0,xhci,tablet test")
+}
@@ -3,9 +3,7 @@ package vm
 import "bytes"
 import "io"
 import "os"
-import "os/exec"
 import "strings"
-import "syscall"
 
 import "github.com/3ofcoins/bheekeeper/cli"
 
@@ -18,30 +16,36 @@ func withStderr(newStderr io.Writer, fn func()) {
 	fn()
 }
 
-func cmd(stdin io.Reader, stdout io.Writer, command string, args ...string) *exec.Cmd {
-	cmd := exec.Command(command, args...)
-	cmd.Stdin = stdin
-	cmd.Stdout = stdout
-	cmd.Stderr = os.Stderr
-	return cmd
+func cmd(stdin io.Reader, stdout io.Writer, command string, args ...string) *Cmd {
+	return &Cmd{
+		Path:   command,
+		Args:   args,
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	}
 }
 
-func run(stdin io.Reader, stdout io.Writer, command string, args ...string) error {
+func run(ex Executor, stdin io.Reader, stdout io.Writer, command string, args ...string) error {
 	cli.Debugf("+ %s %v", command, args)
-	return cmd(stdin, stdout, command, args...).Run()
+	if proc, err := ex.Start(cmd(stdin, stdout, command, args...)); err != nil {
+		return err
+	} else {
+		return proc.Wait()
+	}
 }
 
-func runStatus(stdin io.Reader, stdout io.Writer, command string, args ...string) (int, error) {
-	if err := run(stdin, stdout, command, args...); err != nil {
+func runStatus(ex Executor, stdin io.Reader, stdout io.Writer, command string, args ...string) (int, error) {
+	if err := run(ex, stdin, stdout, command, args...); err != nil {
 		switch err.(type) {
-		case *exec.ExitError:
-			ws := err.(*exec.ExitError).Sys().(syscall.WaitStatus)
-			if ws.Signaled() {
-				cli.Debugf("%s killed by %s", command, ws.Signal())
-				return ws.ExitStatus(), err
+		case *ExitError:
+			ee := err.(*ExitError)
+			if ee.Signaled() {
+				cli.Debugf("%s killed by %s", command, ee.Signal)
+				return ee.Status, err
 			} else {
-				cli.Debugf("%s exited %d", command, ws.ExitStatus())
-				return ws.ExitStatus(), nil
+				cli.Debugf("%s exited %d", command, ee.Status)
+				return ee.Status, nil
 			}
 		default:
 			return -1, err
@@ -50,15 +54,15 @@ func runStatus(stdin io.Reader, stdout io.Writer, command string, args ...string
 	return 0, nil
 }
 
-func runStdout(stdin io.Reader, command string, args ...string) (string, error) {
+func runStdout(ex Executor, stdin io.Reader, command string, args ...string) (string, error) {
 	var buf bytes.Buffer
-	err := run(stdin, &buf, command, args...)
+	err := run(ex, stdin, &buf, command, args...)
 	return buf.String(), err
 }
 
-func zfs_peek(cmd string, arg ...string) ([][]string, error) {
+func zfs_peek(ex Executor, cmd string, arg ...string) ([][]string, error) {
 	arg = append([]string{cmd, "-H"}, arg...)
-	if out, err := runStdout(nil, "zfs", arg...); err != nil {
+	if out, err := runStdout(ex, nil, "zfs", arg...); err != nil {
 		return nil, err
 	} else {
 		lines := strings.Split(out, "\n")
@@ -70,3 +74,9 @@ func zfs_peek(cmd string, arg ...string) ([][]string, error) {
 		return words, nil
 	}
 }
+
+// splitList splits a property value listing items separated by spaces
+// or commas.
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })
+}
@@ -0,0 +1,67 @@
+package vm
+
+import "fmt"
+import "os"
+import "sort"
+import "strings"
+
+const channelPrefix = "channel:"
+
+// Maximum number of ports of a virtio-console device
+const maxChannels = 16
+
+// HasEntropy returns true if the VM has a virtio-rnd device.
+func (vm *VM) HasEntropy() bool {
+	hasEntropy, _ := ParseBool(vm.Property("entropy"))
+	return hasEntropy
+}
+
+// Channel is a virtio-console port, backed by a unix socket on the host.
+type Channel struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Channels returns the VM's virtio-console ports enabled with
+// channel:NAME properties, ordered by name.
+func (vm *VM) Channels() ([]*Channel, error) {
+	var channels []*Channel
+	for name, value := range vm.Properties {
+		if !strings.HasPrefix(name, channelPrefix) {
+			continue
+		}
+		if enabled, _ := ParseBool(value); !enabled {
+			continue
+		}
+		port := name[len(channelPrefix):]
+		channels = append(channels, &Channel{port, vm.runPath("channel." + port + ".sock")})
+	}
+	if len(channels) > maxChannels {
+		return nil, fmt.Errorf("Too many channels: %d, at most %d are supported", len(channels), maxChannels)
+	}
+	sort.Sort(channelsByName(channels))
+	return channels, nil
+}
+
+// consoleDevice returns bhyve's device specification (without the slot)
+// of virtio-console device with all the channels, removing their stale
+// sockets.
+func (vm *VM) consoleDevice(channels []*Channel) (string, error) {
+	if err := os.MkdirAll(vm.runPath(""), 0755); err != nil {
+		return "", err
+	}
+	dev := "virtio-console"
+	for _, ch := range channels {
+		if err := os.Remove(ch.Path); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		dev += "," + ch.Name + "=" + ch.Path
+	}
+	return dev, nil
+}
+
+type channelsByName []*Channel
+
+func (cc channelsByName) Len() int           { return len(cc) }
+func (cc channelsByName) Less(i, j int) bool { return cc[i].Name < cc[j].Name }
+func (cc channelsByName) Swap(i, j int)      { cc[i], cc[j] = cc[j], cc[i] }
@@ -0,0 +1,61 @@
+package vm
+
+import "io/ioutil"
+import "os"
+import "strconv"
+import "strings"
+import "testing"
+
+func TestLoadVirtio(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["loader"] = "bhyveload"
+	vm.Properties["entropy"] = "yes"
+	vm.Properties["channel:org.qemu.guest_agent.0"] = "yes"
+	vm.Properties["channel:disabled"] = "no"
+	vm.Properties["pci:rnd"] = "10"
+
+	sock := vm.runPath("channel.org.qemu.guest_agent.0.sock")
+	if err := os.MkdirAll(vm.runPath(""), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(sock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	expectArgs(t, vm, "-c 1 -m 1024 -A -P -H -l com1,stdio"+
+		" -s 0:0,hostbridge -s 1:0,lpc -s 2:0,virtio-blk,/dev/zvol/tank/test"+
+		" -s 3:0,virtio-net,tap0,mac="+vm.MAC()+
+		" -s 4:0,virtio-console,org.qemu.guest_agent.0="+sock+
+		" -s 10:0,virtio-rnd test")
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("Stale channel socket not removed: %v", err)
+	}
+}
+
+// Invalid names are rejected when the property is set, not when the VM
+// is started.
+func TestChannelNames(t *testing.T) {
+	vm, ex := testVM(t)
+	for _, name := range []string{"Bad", "bad name", "bad,name", "bad=name", strings.Repeat("c", 256)} {
+		if err := vm.SetProperties(map[string]string{"channel:" + name: "yes"}); err == nil {
+			t.Errorf("Accepted channel name %#v", name)
+		}
+	}
+	if err := vm.SetProperties(map[string]string{"channel:org.qemu.guest_agent.0": "yes"}); err != nil {
+		t.Error(err)
+	}
+	expectCommands(t, ex, "zfs", "zfs set bhyve:channel:org.qemu.guest_agent.0=yes tank/test")
+}
+
+func TestChannelsInvalid(t *testing.T) {
+	vm, _ := testVM(t)
+	for i := 0; i <= maxChannels; i++ {
+		vm.Properties["channel:port"+strconv.Itoa(i)] = "yes"
+	}
+	if _, err := vm.Channels(); err == nil {
+		t.Error("Accepted too many channels")
+	}
+}
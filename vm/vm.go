@@ -1,19 +1,15 @@
 package vm
 
 import "bytes"
-import "crypto/md5"
 import "errors"
 import "fmt"
 import "io"
 import "io/ioutil"
-import "net"
 import "os"
-import "os/exec"
 import "path/filepath"
-import "regexp"
 import "strconv"
 import "strings"
-import "syscall"
+import "time"
 
 import "github.com/3ofcoins/bheekeeper/cli" // FIXME? UI part seems awfully clunky
 
@@ -22,29 +18,44 @@ var ErrVMNotFound = errors.New("VM not found")
 type VM struct {
 	Name, Volume string
 	Properties   map[string]string
-	tap          string
+	sources      map[string]string
+	taps         []string
+	bridges      []string  // the taps were added to
+	filesystem   bool      // Volume is a filesystem with boot disk image
+	mountpoint   string    // Volume's mountpoint, if it's a filesystem
+	console      string    // nmdm device of proxied console, stdio if empty
+	conlog       io.Writer // console log, while the VM is being run on stdio
+	attached     bool      // terminal is attached to proxied console
+	state        *State    // runtime state, while the VM is being run
 	loaded       bool
-	*exec.Cmd
+	ex           Executor
+	*Cmd
 }
 
-func NewVM(name, volume string) *VM {
-	return &VM{Name: name, Volume: volume, Properties: make(map[string]string)}
+func NewVM(ex Executor, name, volume string) *VM {
+	return &VM{
+		Name:       name,
+		Volume:     volume,
+		Properties: make(map[string]string),
+		sources:    make(map[string]string),
+		ex:         ex,
+	}
 }
 
-func AllVMs() ([]*VM, error) {
-	if lines, err := zfs_peek("get", "-t", "volume", "-s", "local", "-o", "value,name", "bhyve:name"); err != nil {
+func AllVMs(ex Executor) ([]*VM, error) {
+	if lines, err := zfs_peek(ex, "get", "-t", "volume,filesystem", "-s", "local", "-o", "value,name", "bhyve:name"); err != nil {
 		return nil, err
 	} else {
 		vms := make([]*VM, len(lines))
 		for i, line := range lines {
-			vms[i] = NewVM(line[0], line[1])
+			vms[i] = NewVM(ex, line[0], line[1])
 		}
 		return vms, nil
 	}
 }
 
-func FindVM(name string) (*VM, error) {
-	if vms, err := AllVMs(); err != nil {
+func FindVM(ex Executor, name string) (*VM, error) {
+	if vms, err := AllVMs(ex); err != nil {
 		return nil, err
 	} else {
 		for _, vm := range vms {
@@ -57,90 +68,11 @@ func FindVM(name string) (*VM, error) {
 	return nil, ErrVMNotFound
 }
 
-func (vm *VM) LoadProperties() error {
-	props, err := zfs_peek("get", "-o", "property,value", "all", vm.Volume)
-	if err != nil {
-		return err
-	}
-	for _, prop := range props {
-		if !strings.HasPrefix(prop[0], "bhyve:") {
-			continue
-		}
-		vm.Properties[prop[0][6:]] = prop[1]
-	}
-	return nil
-}
-
-func (vm *VM) MAC() string {
-	hsh := md5.Sum([]byte(vm.Name))
-	hw := make(net.HardwareAddr, 6)
-	hw[0] = 0x02
-	hw[1] = 0xAB
-	hw[2] = 0xEE
-	hw[3] = hsh[0]
-	hw[4] = hsh[1]
-	hw[5] = hsh[2]
-	return hw.String()
-}
-
-var PropertyDefaults = map[string]string{
-	"bridge":    "bridge0",
-	"cpus":      "1",
-	"grub:root": "hd0,msdos1",
-	"mem":       "1024",
-}
-
-func (vm *VM) Property(name string) string {
-	if val, exists := vm.Properties[name]; exists {
-		return val
-	} else {
-		return PropertyDefaults[name]
-	}
-}
-
-func (vm *VM) Bridge() string {
-	bridge := vm.Property("bridge")
-	if _, err := net.InterfaceByName(bridge); err != nil {
-		if err := run(nil, os.Stdout, "ifconfig", bridge, "create"); err != nil {
-			panic(err)
-		}
-	}
-	return bridge
-}
-
-var rxSpace = regexp.MustCompile(`\s+`)
-
-func (vm *VM) Tap(create bool) string {
-	if pid := vm.BhyvePid(); vm.tap == "" && pid != 0 {
-		if out, err := runStdout(nil, "fstat", "-p", strconv.Itoa(pid), "-f", "/dev"); err != nil {
-			cli.Error(err)
-		} else {
-			for _, ln := range strings.Split(out, "\n") {
-				if ln == "" {
-					continue
-				}
-				lnw := rxSpace.Split(ln, -1)
-				if dev := lnw[len(lnw)-2]; strings.HasPrefix(dev, "tap") {
-					vm.tap = dev
-				}
-			}
-		}
-	}
-	if vm.tap == "" && create {
-		if tap, err := runStdout(nil, "ifconfig", "tap", "create"); err != nil {
-			panic(err)
-		} else {
-			vm.tap = strings.TrimSpace(tap)
-			if err := run(nil, os.Stdout, "ifconfig", vm.Bridge(), "addm", vm.tap); err != nil {
-				panic(err)
-			}
-		}
-	}
-	return vm.tap
-}
+// Directory with vmm devices of existing VMs
+var VMMDir = "/dev/vmm"
 
 func (vm *VM) vmmPath() string {
-	return filepath.Join("/dev/vmm", vm.Name)
+	return filepath.Join(VMMDir, vm.Name)
 }
 
 func (vm *VM) BhyvePid() int {
@@ -151,8 +83,14 @@ func (vm *VM) BhyvePid() int {
 		return 0
 	}
 
+	if st := vm.State(); st != nil && st.Pid != 0 {
+		return st.Pid
+	}
+
+	// Fall back to fuser for VMs started by older versions, or not
+	// recorded yet
 	withStderr(nil, func() {
-		out, err = runStdout(nil, "fuser", vm.vmmPath())
+		out, err = runStdout(vm.ex, nil, "fuser", vm.vmmPath())
 	})
 
 	if err != nil {
@@ -176,23 +114,27 @@ func (vm *VM) Exists() bool {
 
 func (vm *VM) RunBhyvectl(args ...string) error {
 	args = append([]string{"--vm=" + vm.Name}, args...)
-	return run(nil, os.Stdout, "bhyvectl", args...)
+	return run(vm.ex, nil, os.Stdout, "bhyvectl", args...)
 }
 
 func (vm *VM) Destroy() {
 	if vm.Exists() {
 		vm.RunBhyvectl("--destroy")
 	}
-	if vm.tap != "" {
-		run(nil, os.Stdout, "ifconfig", vm.Bridge(), "deletem", vm.tap)
-		run(nil, os.Stdout, "ifconfig", vm.tap, "destroy")
-		vm.tap = ""
-	}
+	vm.destroyTaps()
 	vm.loaded = false
 	vm.Cmd = nil
 }
 
+// Name of the boot disk image file in VM's filesystem dataset
+const DiskImageName = "disk0.img"
+
+// volumePath returns path of the VM's default boot disk: its ZFS
+// volume, or image file in its filesystem dataset.
 func (vm *VM) volumePath() string {
+	if vm.filesystem {
+		return filepath.Join(vm.mountpoint, DiskImageName)
+	}
 	return filepath.Join("/dev/zvol", vm.Volume)
 }
 
@@ -204,7 +146,17 @@ func (vm *VM) RunGrub(in io.Reader) error {
 
 	defer os.Remove(deviceMap.Name())
 
-	deviceMapLines := []string{fmt.Sprintf("(hd0) %s\n", vm.volumePath())}
+	disks, err := vm.Disks()
+	if err != nil {
+		return err
+	}
+
+	// Numbered like disk<N> properties, so that grub:root can refer to
+	// them
+	var deviceMapLines []string
+	for _, disk := range disks {
+		deviceMapLines = append(deviceMapLines, fmt.Sprintf("(hd%d) %s\n", disk.Index, disk.Path))
+	}
 	if iso := vm.Property("cdrom_iso"); iso != "" {
 		deviceMapLines = append(deviceMapLines, fmt.Sprintf("(cd0) %s\n", iso))
 	}
@@ -217,61 +169,158 @@ func (vm *VM) RunGrub(in io.Reader) error {
 		return err
 	}
 
-	return run(in, os.Stdout, "grub-bhyve",
+	args := []string{
 		"-r", vm.Property("grub:root"),
 		"-m", deviceMap.Name(),
-		"-M", vm.Property("mem"),
-		vm.Name)
+		"-M", vm.memory()}
+	if vm.wiredMemory() {
+		args = append(args, "-S")
+	}
+	stdin, console := vm.loaderConsole(in)
+	if console != "" {
+		args = append(args, "-c", console)
+	}
+	args = append(args, vm.Name)
+
+	return run(vm.ex, stdin, os.Stdout, "grub-bhyve", args...)
+}
+
+// loaderInput returns input for the loader configured in the named
+// property: stdin for "-", or contents of a Go-quoted string.
+func (vm *VM) loaderInput(property string) (io.Reader, error) {
+	if inStr, exists := vm.Properties[property]; exists {
+		if inStr == "-" {
+			return os.Stdin, nil
+		} else if strings.HasPrefix(inStr, "\"") {
+			inStr, err := strconv.Unquote(inStr)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewBufferString(inStr), nil
+		}
+	}
+	return nil, nil
+}
+
+func (vm *VM) loadGrub() error {
+	if in, err := vm.loaderInput("grub:in"); err != nil {
+		return err
+	} else {
+		return vm.RunGrub(in)
+	}
+}
+
+// loaderConsole returns stdin and console device (if any) for a loader
+// that reads input from in. Scripted input is piped to the loader, which
+// then runs on stdio rather than on the VM's console; input from stdin
+// is typed on the console, if the terminal is attached to it.
+func (vm *VM) loaderConsole(in io.Reader) (io.Reader, string) {
+	if vm.console == "" || (in != nil && in != os.Stdin) {
+		return in, ""
+	}
+	return nil, vm.console
+}
+
+func (vm *VM) consoleBackend() string {
+	if vm.console != "" {
+		return vm.console
+	}
+	return "stdio"
 }
 
 var ErrLoaded = errors.New("Already loaded")
 
+// memory returns VM's memory size in megabytes, as passed to bhyve and
+// grub-bhyve.
+func (vm *VM) memory() string {
+	mem, _ := ParseSize(vm.Property("mem"))
+	return strconv.FormatInt(mem, 10)
+}
+
+// wiredMemory returns true if the VM's memory is wired: when set with
+// mem:wired, or when PCI passthrough requires it. The loaders need to
+// be told too, as they allocate the VM's memory.
+func (vm *VM) wiredMemory() bool {
+	wired, _ := ParseBool(vm.Property("mem:wired"))
+	return wired || len(vm.Passthru()) > 0
+}
+
 func (vm *VM) Load() error {
 	if vm.loaded {
 		return ErrLoaded
 	}
 
-	var grubInRd io.Reader
-	if grubInStr, exists := vm.Properties["grub:in"]; exists {
-		if grubInStr == "-" {
-			grubInRd = os.Stdin
-		} else if strings.HasPrefix(grubInStr, "\"") {
-			grubInStr, err := strconv.Unquote(grubInStr)
-			if err != nil {
-				return err
-			}
-			grubInRd = bytes.NewBufferString(grubInStr)
-		}
+	if err := vm.Validate(); err != nil {
+		return err
 	}
-	if err := vm.RunGrub(grubInRd); err != nil {
+
+	if err := vm.checkPassthruAvailable(); err != nil {
 		return err
 	}
 
-	args := []string{
-		"-c", vm.Property("cpus"),
-		"-m", vm.Property("mem"),
-		"-A", "-P", "-H",
-		"-s", "0,hostbridge",
-		"-s", "1,lpc",
-		"-s", "2:0,virtio-blk," + vm.volumePath(),
-		"-s", "3,virtio-net," + vm.Tap(true) + ",mac=" + vm.MAC(),
-		"-l", "com1,stdio"}
+	var loaderArgs []string
+	switch vm.Property("loader") {
+	case "uefi", "uefi-csm":
+		if args, err := vm.bootromArgs(); err != nil {
+			return err
+		} else {
+			loaderArgs = args
+		}
+	case "bhyveload":
+		if err := vm.loadBhyveload(); err != nil {
+			return err
+		}
+	default:
+		if err := vm.loadGrub(); err != nil {
+			return err
+		}
+	}
 
-	if iso := vm.Property("cdrom_iso"); iso != "" {
-		args = append(args, "-s", "2:1,ahci-cd,"+iso)
+	devs, err := vm.loadPCIDevices()
+	if err != nil {
+		// Don't leave the taps, or the VM created by the loader, behind
+		vm.Destroy()
+		return err
 	}
 
+	args := append(vm.cpuArgs(),
+		"-m", vm.memory(),
+		"-A", "-P", "-H")
+	args = append(args, vm.serialArgs()...)
+	if vm.wiredMemory() {
+		args = append(args, "-S")
+	}
+	args = append(args, loaderArgs...)
+	for _, dev := range devs {
+		args = append(args, "-s", dev.Arg())
+	}
 	args = append(args, vm.Name)
 
-	vm.Cmd = exec.Command("bhyve", args...)
-	vm.Stdin = os.Stdin
-	vm.Stdout = os.Stdout
-	vm.Stderr = os.Stderr
+	vm.Cmd = &Cmd{
+		Path:   "bhyve",
+		Args:   args,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	// VMs run with Load and Run directly (e.g. by the packer builder)
+	// have the console on stdio
+	if vm.console == "" {
+		vm.Stdin, vm.Stdout = os.Stdin, vm.stdioOutput()
+	}
 
 	vm.loaded = true
 	return nil
 }
 
+// stdioOutput returns where bhyve's output goes when the console is on
+// stdio: the terminal, and the console log while the VM is being run.
+func (vm *VM) stdioOutput() io.Writer {
+	if vm.conlog != nil {
+		return io.MultiWriter(os.Stdout, vm.conlog)
+	}
+	return os.Stdout
+}
+
 func (vm *VM) EnsureLoaded() error {
 	if !vm.loaded {
 		return vm.Load()
@@ -282,10 +331,11 @@ func (vm *VM) EnsureLoaded() error {
 type VMStatus int
 
 const (
-	VMError    = VMStatus(-1)
-	VMRebooted = VMStatus(0)
-	VMPoweroff = VMStatus(1)
-	VMHalted   = VMStatus(2)
+	VMError       = VMStatus(-1)
+	VMRebooted    = VMStatus(0)
+	VMPoweroff    = VMStatus(1)
+	VMHalted      = VMStatus(2)
+	VMTripleFault = VMStatus(3) // counts as a crash
 )
 
 func (s VMStatus) String() string {
@@ -298,6 +348,8 @@ func (s VMStatus) String() string {
 		return "Poweroff"
 	case VMHalted:
 		return "Halted"
+	case VMTripleFault:
+		return "TripleFault"
 	default:
 		return fmt.Sprintf("WTF%d", s)
 	}
@@ -309,13 +361,25 @@ func (vm *VM) Run1() (VMStatus, error) {
 	}
 	defer vm.Destroy()
 
-	switch err := vm.Cmd.Run(); err.(type) {
+	proc, err := vm.ex.Start(vm.Cmd)
+	if err != nil {
+		return VMError, err
+	}
+
+	if vm.state != nil {
+		vm.state.Pid = proc.Pid()
+		vm.state.Taps, vm.state.Bridges = vm.taps, vm.bridges
+		vm.state.Boots++
+		vm.saveState()
+	}
+
+	switch err := proc.Wait(); err.(type) {
 	case nil:
 		return VMRebooted, nil
-	case *exec.ExitError:
-		ws := err.(*exec.ExitError).Sys().(syscall.WaitStatus)
-		if ws.Exited() && ws.ExitStatus() < 3 {
-			return VMStatus(ws.ExitStatus()), nil
+	case *ExitError:
+		ee := err.(*ExitError)
+		if !ee.Signaled() && ee.Status <= 3 {
+			return VMStatus(ee.Status), nil
 		} else {
 			return VMError, err
 		}
@@ -324,15 +388,65 @@ func (vm *VM) Run1() (VMStatus, error) {
 	}
 }
 
+// Run runs the VM, restarting it according to the restart property,
+// until it stops. The console is on stdio, unless it's proxied by
+// RunAttached or Supervise.
 func (vm *VM) Run() error {
+	// A VM loaded by the caller already exists, and a supervisor's Run
+	// holds its own pidfile
+	if pid := vm.runnerPid(); (vm.Exists() && !vm.loaded) || (pid != 0 && pid != os.Getpid()) {
+		return ErrAlreadyRunning
+	}
+
+	vm.state = &State{Runner: os.Getpid(), Console: "stdio", StartedAt: time.Now()}
+	if vm.console != "" {
+		vm.state.Console = vm.ConsoleSocket()
+	}
+	vm.saveState()
+	defer vm.clearState()
+
+	stop, err := vm.startSerialProxies()
+	if err != nil {
+		return err
+	}
+	if vm.loaded && vm.console == "" {
+		// Loaded by the caller, before the console log was opened
+		vm.Stdout = vm.stdioOutput()
+	}
+	var attached chan error
+	if vm.attached {
+		attached = make(chan error, 1)
+		go func() { attached <- vm.AttachConsole() }()
+	}
+	defer func() {
+		stop()
+		if attached != nil {
+			if err := <-attached; err != nil {
+				cli.Error(err)
+			}
+		}
+	}()
+
+	vm.stopRequested() // clear a stale request
+	restarts := vm.newRestarter()
 	for {
-		if status, err := vm.Run1(); err != nil {
-			return err
-		} else {
+		vm.state.Pid, vm.state.Taps, vm.state.Bridges = 0, nil, nil
+		vm.saveState()
+		status, err := vm.Run1()
+		if err != nil && vm.state.Pid == 0 {
+			return err // bhyve didn't even start
+		}
+		if err == nil {
 			cli.Info(status.String())
-			if status != VMRebooted {
-				return nil
-			}
+		}
+		if vm.stopRequested() || !vm.shouldRestart(status) {
+			return err
+		}
+		if err != nil {
+			cli.Error(err)
+		}
+		if !restarts.restart(status) {
+			return err
 		}
 	}
 }
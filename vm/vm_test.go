@@ -0,0 +1,111 @@
+package vm
+
+import "fmt"
+import "io/ioutil"
+import "path/filepath"
+import "reflect"
+import "strings"
+import "testing"
+
+// testVM returns VM "test" on volume tank/test, with host directories
+// pointing to temporary ones, and a FakeExecutor for a host with 4 CPUs
+// that hands out tap0, tap1, ... when asked for a tap.
+func testVM(t *testing.T) (*VM, *FakeExecutor) {
+	for _, dir := range []*string{&RunDir, &DataDir, &VMMDir, &UEFIFirmwareDir} {
+		dir, orig := dir, *dir
+		*dir = t.TempDir()
+		t.Cleanup(func() { *dir = orig })
+	}
+
+	ex := NewFakeExecutor()
+	ex.On("sysctl", "-n", "hw.ncpu").Output("4\n")
+	taps := 0
+	ex.On("ifconfig", "tap", "create").Do(func(c *Cmd) error {
+		fmt.Fprintf(c.Stdout, "tap%d\n", taps)
+		taps++
+		return nil
+	})
+
+	vm := NewVM(ex, "test", "tank/test")
+	vm.Properties["bridge"] = "bktest0" // so that it doesn't exist on the host
+	return vm, ex
+}
+
+// callsOf returns recorded calls that run path.
+func callsOf(ex *FakeExecutor, path string) []*Cmd {
+	var calls []*Cmd
+	for _, c := range ex.Calls {
+		if c.Path == path {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// commandsOf returns recorded commands that run path.
+func commandsOf(ex *FakeExecutor, path string) []string {
+	var cmds []string
+	for _, c := range callsOf(ex, path) {
+		cmds = append(cmds, strings.Join(append([]string{c.Path}, c.Args...), " "))
+	}
+	return cmds
+}
+
+func expectCommands(t *testing.T, ex *FakeExecutor, path string, expected ...string) {
+	t.Helper()
+	if actual := commandsOf(ex, path); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%s commands:\n  got:      %#v\n  expected: %#v", path, actual, expected)
+	}
+}
+
+func expectArgs(t *testing.T, vm *VM, expected string) {
+	t.Helper()
+	if vm.Cmd == nil {
+		t.Fatal("VM is not loaded")
+	}
+	if actual := strings.Join(vm.Cmd.Args, " "); actual != expected {
+		t.Errorf("bhyve args:\n  got:      %s\n  expected: %s", actual, expected)
+	}
+}
+
+func TestLoadGrub(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["mem"] = "2G"
+	vm.Properties["cdrom_iso"] = "/dev/null"
+	vm.Properties["grub:in"] = `"boot\n"`
+	vm.Properties["mem:wired"] = "yes"
+
+	var deviceMap, grubIn string
+	ex.On("grub-bhyve").Do(func(c *Cmd) error {
+		buf, err := ioutil.ReadFile(c.Args[3])
+		deviceMap = string(buf)
+		buf, _ = ioutil.ReadAll(c.Stdin)
+		grubIn = string(buf)
+		return err
+	})
+
+	if err := vm.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	grub := callsOf(ex, "grub-bhyve")
+	if len(grub) != 1 {
+		t.Fatalf("Expected one grub-bhyve call, got %#v", commandsOf(ex, "grub-bhyve"))
+	}
+	args := grub[0].Args
+	if !reflect.DeepEqual(args[:2], []string{"-r", "hd0,msdos1"}) ||
+		!strings.HasPrefix(filepath.Base(args[3]), "bheekeper_device.map_") ||
+		!reflect.DeepEqual(args[4:], []string{"-M", "2048", "-S", "test"}) {
+		t.Errorf("Unexpected grub-bhyve args: %#v", args)
+	}
+	if expected := "(hd0) /dev/zvol/tank/test\n(cd0) /dev/null\n"; deviceMap != expected {
+		t.Errorf("Device map: %#v, expected %#v", deviceMap, expected)
+	}
+	if grubIn != "boot\n" {
+		t.Errorf("grub-bhyve input: %#v", grubIn)
+	}
+
+	expectArgs(t, vm, "-c 1 -m 2048 -A -P -H -l com1,stdio -S"+
+		" -s 0:0,hostbridge -s 1:0,lpc -s 2:0,virtio-blk,/dev/zvol/tank/test -s 2:1,ahci-cd,/dev/null"+
+		" -s 3:0,virtio-net,tap0,mac="+vm.MAC()+" test")
+}
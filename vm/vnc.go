@@ -0,0 +1,152 @@
+package vm
+
+import "errors"
+import "fmt"
+import "net"
+import "strconv"
+import "strings"
+
+// First port tried when allocating VNC port automatically
+var VNCPortBase = 5900
+
+// Number of ports tried when allocating VNC port automatically
+var VNCPortRange = 100
+
+// ParseResolution parses framebuffer resolution: WIDTHxHEIGHT.
+func ParseResolution(value string) (int, int, error) {
+	parts := strings.Split(value, "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid resolution %#v, expected WIDTHxHEIGHT", value)
+	}
+	w, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid width %#v", parts[0])
+	}
+	h, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid height %#v", parts[1])
+	}
+	if w < 640 || w > 1920 || h < 480 || h > 1200 {
+		return 0, 0, fmt.Errorf("Resolution %dx%d is out of range 640x480..1920x1200", w, h)
+	}
+	return w, h, nil
+}
+
+func checkResolution(ex Executor, value string) error {
+	_, _, err := ParseResolution(value)
+	return err
+}
+
+func checkIP(ex Executor, value string) error {
+	if net.ParseIP(value) == nil {
+		return fmt.Errorf("Invalid IP address %#v", value)
+	}
+	return nil
+}
+
+// Commas separate options of bhyve's device specification
+func checkVNCPassword(ex Executor, value string) error {
+	if strings.Contains(value, ",") {
+		return errors.New("VNC password can't contain commas")
+	}
+	return nil
+}
+
+// HasVNC returns true if the VM has a VNC framebuffer.
+func (vm *VM) HasVNC() bool {
+	hasVNC, _ := ParseBool(vm.Property("vnc"))
+	return hasVNC
+}
+
+func (vm *VM) checkVNC() error {
+	if !vm.HasVNC() {
+		return nil
+	}
+	switch vm.Property("loader") {
+	case "uefi", "uefi-csm":
+		return nil
+	default:
+		return fmt.Errorf("vnc: requires loader uefi or uefi-csm")
+	}
+}
+
+// VNCAddress returns address of the VM's VNC server: the one used by the
+// running VM, or the configured one. It is empty if the VM has no VNC
+// server, or if its port will be allocated when the VM starts.
+func (vm *VM) VNCAddress() string {
+	if st := vm.State(); st != nil && st.VNC != "" {
+		return st.VNC
+	}
+	if port := vm.Property("vnc:port"); !vm.HasVNC() || port == "" {
+		return ""
+	} else {
+		return net.JoinHostPort(vm.Property("vnc:listen"), port)
+	}
+}
+
+// vncAddress returns address for the VM's VNC server to listen on. The
+// address is kept for the whole run, so that it doesn't change when
+// the VM reboots; if vnc:port is not set, first free port starting at
+// VNCPortBase is used. A port is not free if another running VM has
+// it, even if its bhyve is not listening on it yet (or at the moment).
+func (vm *VM) vncAddress() (string, error) {
+	if vm.state != nil && vm.state.VNC != "" {
+		return vm.state.VNC, nil
+	}
+	listen := vm.Property("vnc:listen")
+	if port := vm.Property("vnc:port"); port != "" {
+		return net.JoinHostPort(listen, port), nil
+	}
+
+	taken := make(map[string]bool)
+	if vms, err := AllVMs(vm.ex); err != nil {
+		return "", err
+	} else {
+		for _, other := range vms {
+			if st := other.State(); other.Name != vm.Name && st != nil && st.VNC != "" {
+				if _, port, err := net.SplitHostPort(st.VNC); err == nil {
+					taken[port] = true
+				}
+			}
+		}
+	}
+
+	for port := VNCPortBase; port < VNCPortBase+VNCPortRange; port++ {
+		if taken[strconv.Itoa(port)] {
+			continue
+		}
+		addr := net.JoinHostPort(listen, strconv.Itoa(port))
+		if l, err := net.Listen("tcp", addr); err == nil {
+			l.Close()
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("No free VNC port on %s in %d..%d", listen, VNCPortBase, VNCPortBase+VNCPortRange-1)
+}
+
+// vncDevices returns bhyve's device specifications (without the slot)
+// of the VM's framebuffer and tablet.
+func (vm *VM) vncDevices() ([]string, error) {
+	addr, err := vm.vncAddress()
+	if err != nil {
+		return nil, err
+	}
+	if vm.state != nil {
+		// Record the port right away, for other VMs to see it taken
+		vm.state.VNC = addr
+		vm.saveState()
+	}
+
+	w, h, err := ParseResolution(vm.Property("vnc:resolution"))
+	if err != nil {
+		return nil, err
+	}
+	fbuf := fmt.Sprintf("fbuf,tcp=%s,w=%d,h=%d", addr, w, h)
+	if password := vm.Property("vnc:password"); password != "" {
+		fbuf += ",password=" + password
+	}
+	if wait, _ := ParseBool(vm.Property("vnc:wait")); wait {
+		fbuf += ",wait"
+	}
+	return []string{fbuf, "xhci,tablet"}, nil
+}
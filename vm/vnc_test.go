@@ -0,0 +1,96 @@
+package vm
+
+import "os"
+import "testing"
+
+func TestVNCAddress(t *testing.T) {
+	vm, _ := testVM(t)
+	if addr := vm.VNCAddress(); addr != "" {
+		t.Errorf("VNC address without VNC: %#v", addr)
+	}
+
+	vm.Properties["vnc"] = "yes"
+	if addr := vm.VNCAddress(); addr != "" {
+		t.Errorf("VNC address before port is allocated: %#v", addr)
+	}
+
+	vm.Properties["vnc:port"] = "5910"
+	if addr := vm.VNCAddress(); addr != "127.0.0.1:5910" {
+		t.Errorf("Configured VNC address: %#v", addr)
+	}
+
+	vm.state = &State{Runner: os.Getpid(), VNC: "127.0.0.1:5901"}
+	vm.saveState()
+	if addr := vm.VNCAddress(); addr != "127.0.0.1:5901" {
+		t.Errorf("VNC address of running VM: %#v", addr)
+	}
+}
+
+func TestVNCPortAllocation(t *testing.T) {
+	vm, ex := testVM(t)
+	vm.Properties["vnc"] = "yes"
+	vm.state = &State{Runner: os.Getpid()}
+
+	other := NewVM(ex, "other", "tank/other")
+	other.state = &State{Runner: os.Getpid(), VNC: "127.0.0.1:5990"}
+	other.saveState()
+	ex.On("zfs", "get", "-H", "-t").Output("test\ttank/test\nother\ttank/other\n")
+
+	defer func(base int) { VNCPortBase = base }(VNCPortBase)
+	VNCPortBase = 5990
+	addr, err := vm.vncAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr == "127.0.0.1:5990" {
+		t.Errorf("Allocated port of another running VM: %s", addr)
+	}
+
+	if _, err := vm.vncDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if st := vm.State(); st == nil || st.VNC == "" {
+		t.Errorf("Allocated port is not recorded: %#v", st)
+	}
+}
+
+func TestVNCPasswordHidden(t *testing.T) {
+	vm, _ := testVM(t)
+	vm.Properties["vnc:password"] = "secret"
+	vm.sources["vnc:password"] = "local"
+
+	if prop := vm.EffectiveProperty("vnc:password"); prop.Value != HiddenValue {
+		t.Errorf("vnc:password shown: %#v", prop)
+	}
+	if prop := vm.Info().Properties["vnc:password"]; prop.Value != HiddenValue {
+		t.Errorf("vnc:password shown in info: %#v", prop)
+	}
+	if vm.Property("vnc:password") != "secret" {
+		t.Error("vnc:password hidden from bhyve")
+	}
+}
+
+func TestVNCPasswordHiddenInSnapshots(t *testing.T) {
+	vm, ex := testVM(t)
+	ex.On("zfs", "list").Output("tank/test@base\t1400000000\t1024\n")
+	ex.On("zfs", "get", "-H", "-s", "local", "-o", "property,value", "all", "tank/test@base").
+		Output("bhyve:name\ttest\nbhyve:vnc:password\tsecret\n")
+
+	snaps, err := vm.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 || snaps[0].Properties["vnc:password"] != HiddenValue || snaps[0].Properties["name"] != "test" {
+		t.Errorf("Unexpected snapshots: %#v", snaps)
+	}
+}
+
+func TestVNCPasswordCommas(t *testing.T) {
+	ex := NewFakeExecutor()
+	if err := ValidateProperty(ex, "vnc:password", "pass,wait"); err == nil {
+		t.Error("Accepted password with a comma")
+	}
+	if err := ValidateProperty(ex, "vnc:password", "pass=word"); err != nil {
+		t.Error(err)
+	}
+}